@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit [device|group]",
+	Short: "Find devices that share names or topics, have changed or can't be reached",
+	Long: `Check a device or group, or all configured devices, finding devices that:
+
+  share a topic, device name, friendly name, hostname or mac address with another device
+  have a different mac address to the one configured or to when they were last seen, so their
+  address may now belong to another device
+  can't be reached or read
+
+The mac address of each configured device is kept in a cache to compare with next time. When a
+device has been replaced on purpose, --accept records its new mac address, in the configuration
+too if it has one there.
+
+Exits with 1 if any problems are found.`,
+	Example: `  tasmota-cli audit
+  tasmota-cli audit lamp --accept`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		devices, _, err := resolveTargetsOrAll(args)
+		if err != nil {
+			return err
+		}
+		return runAudit(devices)
+	},
+}
+
+func init() {
+	auditCmd.Flags().Bool("accept", false, "Record the mac addresses of devices that have changed, in the cache and configuration")
+
+	rootCmd.AddCommand(auditCmd)
+}
+
+// a problem found by an audit
+type AuditResult struct {
+	Device  string `json:"Device"`
+	Problem string `json:"Problem"`
+}
+
+// check devices for conflicts and changes, returning an error if there are any problems
+func runAudit(devices []Device) error {
+	statuses := make([]StatusResponse, len(devices))
+	errs := queryDevices(devices, func(i int, dev Device) error {
+		response, err := sendTasmota(dev, commandList["statusall"])
+		if err != nil {
+			return err
+		}
+		return decodeResponse(dev.Host, response, &statuses[i])
+	})
+
+	cache, err := loadCache()
+	if err != nil {
+		return err
+	}
+
+	problems, accepted := auditDevices(devices, statuses, errs, cache, time.Now(), viper.GetBool("accept"))
+	if err := cache.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not save %s: %s\n", cache.path, err)
+	}
+	if err := acceptMacs(accepted); err != nil {
+		return fmt.Errorf("could not update the configuration: %s", err)
+	}
+
+	if err := render(os.Stdout, auditOutput(problems)); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		// the problems have been printed, so only the exit code is left
+		return &CheckError{State: healthWarn}
+	}
+	return nil
+}
+
+// find devices that can't be read, share names, or have changed since they were last seen,
+// recording what was seen of configured devices in the cache, and with accept returning the new
+// mac address of each device whose configured one is different
+func auditDevices(devices []Device, statuses []StatusResponse, errs []error, cache *deviceCache, now time.Time, accept bool) ([]AuditResult, map[string]string) {
+	var problems []AuditResult
+	accepted := map[string]string{}
+	names := map[string]map[string]string{
+		"topic":         {},
+		"device name":   {},
+		"friendly name": {},
+		"hostname":      {},
+		"mac address":   {},
+	}
+
+	for i, dev := range devices {
+		if errs[i] != nil {
+			problems = append(problems, AuditResult{dev.Name, fmt.Sprintf("can't be read: %s", errs[i])})
+			continue
+		}
+
+		res := statuses[i]
+		names["topic"][dev.Name] = res.Status.Topic
+		names["device name"][dev.Name] = res.Status.DeviceName
+		if len(res.Status.FriendlyName) > 0 {
+			names["friendly name"][dev.Name] = res.Status.FriendlyName[0]
+		}
+		names["hostname"][dev.Name] = res.StatusNET.Hostname
+		names["mac address"][dev.Name] = res.StatusNET.Mac
+
+		if !isConfigured(dev.Name) {
+			continue
+		}
+		if dev.Mac != "" && !sameMac(dev.Mac, res.StatusNET.Mac) {
+			if !accept {
+				problems = append(problems, AuditResult{dev.Name, fmt.Sprintf("mac address is %s, not %s as configured, resolve can find where it has moved to or --accept records the new one",
+					res.StatusNET.Mac, dev.Mac)})
+				continue
+			}
+			accepted[dev.Name] = res.StatusNET.Mac
+		}
+		key := cacheKey(dev.Name)
+		seen, ok := cache.Devices[key]
+		if ok && seen.Mac != "" && !sameMac(seen.Mac, res.StatusNET.Mac) && !accept {
+			// keep the old mac so the change is reported until it is accepted
+			problems = append(problems, AuditResult{dev.Name, fmt.Sprintf("mac address changed from %s to %s since %s, %s may now be another device",
+				seen.Mac, res.StatusNET.Mac, seen.Seen.Format("2006-01-02"), dev.Host)})
+			continue
+		}
+		cache.Devices[key] = cachedDevice{Mac: res.StatusNET.Mac, Address: dev.Host, Seen: now}
+	}
+
+	for _, what := range []string{"topic", "device name", "friendly name", "hostname", "mac address"} {
+		problems = append(problems, duplicates(what, names[what])...)
+	}
+	return problems, accepted
+}
+
+// record accepted mac addresses in the configuration, leaving it alone if there are none
+func acceptMacs(accepted map[string]string) error {
+	if len(accepted) == 0 {
+		return nil
+	}
+
+	return editConfig(func(c *configFile) error {
+		for name, mac := range accepted {
+			if err := setDeviceSetting(c, name, "mac", mac); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// devices sharing a value, such as a topic, each reported with the others it shares it with,
+// values are compared ignoring case and empty values are left out
+func duplicates(what string, values map[string]string) []AuditResult {
+	var devices []string
+	for device := range values {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	byValue := map[string][]string{}
+	for _, device := range devices {
+		if value := strings.ToLower(values[device]); value != "" {
+			byValue[value] = append(byValue[value], device)
+		}
+	}
+
+	var problems []AuditResult
+	for _, device := range devices {
+		shared := byValue[strings.ToLower(values[device])]
+		if len(shared) < 2 {
+			continue
+		}
+		var others []string
+		for _, other := range shared {
+			if other != device {
+				others = append(others, other)
+			}
+		}
+		problems = append(problems, AuditResult{device, fmt.Sprintf("%s %s is also used by %s", what, values[device], strings.Join(others, ", "))})
+	}
+	return problems
+}
+
+// output of problems found by an audit, sorted by device
+func auditOutput(problems []AuditResult) output {
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Device < problems[j].Device })
+
+	o := output{
+		Data:    problems,
+		Columns: []string{"Device", "Problem"},
+	}
+	var text strings.Builder
+	for _, p := range problems {
+		o.Rows = append(o.Rows, []string{p.Device, p.Problem})
+		fmt.Fprintf(&text, "%s: %s\n", p.Device, p.Problem)
+	}
+	if len(problems) == 0 {
+		o.Data = []AuditResult{}
+		text.WriteString("No problems found\n")
+	}
+	o.Text = text.String()
+	return o
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	values := map[string]string{
+		"lamp":   "Kitchen",
+		"strip":  "kitchen",
+		"fan":    "kitchen",
+		"heater": "heater",
+		"new":    "",
+		"other":  "",
+	}
+	want := []AuditResult{
+		{"fan", "name kitchen is also used by lamp, strip"},
+		{"lamp", "name Kitchen is also used by fan, strip"},
+		{"strip", "name kitchen is also used by fan, lamp"},
+	}
+	if got := duplicates("name", values); !reflect.DeepEqual(got, want) {
+		t.Errorf("duplicates = %v, want %v", got, want)
+	}
+}
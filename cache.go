@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// what was last seen of configured devices, kept between runs so changes can be noticed
+type deviceCache struct {
+	path    string
+	Devices map[string]cachedDevice `json:"devices"`
+}
+
+// a device as it was last seen
+type cachedDevice struct {
+	Mac     string    `json:"mac"`
+	Address string    `json:"address"`
+	Seen    time.Time `json:"seen"`
+}
+
+// where the cache is kept, set by the cache setting or in the user's cache directory
+func cachePath() (string, error) {
+	if path := viper.GetString("cache"); path != "" {
+		return expandHome(path), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find a cache directory, use the cache setting: %s", err)
+	}
+	return filepath.Join(dir, applicationName, "devices.json"), nil
+}
+
+// load the cache, which is empty if it hasn't been saved yet
+func loadCache() (*deviceCache, error) {
+	path, err := cachePath()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &deviceCache{path: path, Devices: map[string]cachedDevice{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("%s is not a device cache, remove it to start again: %s", path, err)
+	}
+	if c.Devices == nil {
+		c.Devices = map[string]cachedDevice{}
+	}
+	return c, nil
+}
+
+// write the cache, replacing it in one go so it is never half written
+func (c *deviceCache) save() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".devices-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// the key of a device in the cache, devices of profiles are kept apart as they can share names
+func cacheKey(name string) string {
+	if profile := profileName(); profile != "" {
+		return profile + "/" + name
+	}
+	return name
+}
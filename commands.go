@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// flags of the command being run
+var activeFlags *pflag.FlagSet
+
+// annotation of flags that aren't bound to viper, as they would hide the setting of the same
+// name in the configuration, e.g. a --password for wifi replacing the password of devices
+const unboundFlag = "tascli_unbound"
+
+// root of all commands, running it with the old --cmd and --custom flags is still supported
+var rootCmd = &cobra.Command{
+	Use:   applicationName,
+	Short: "A simple CLI to control tasmota devices",
+	Long: applicationName + " " + applicationVersion + "\n" + applicationUrl + `
+
+A simple CLI to control tasmota devices.
+
+Exit codes:
+  0 success, 1 error, 10 unreachable, 11 timeout, 12 auth required,
+  13 http error, 14 invalid json, 15 unknown command, 16 verify failed
+
+health and --nagios exit as nagios plugins do:
+  0 ok, 1 warning, 2 critical, 3 unknown`,
+	Example: `  tasmota-cli power on lamp
+  tasmota-cli status lamp
+  tasmota-cli timers list lamp
+  tasmota-cli send lamp 'Status 8'`,
+	Version:           applicationVersion,
+	SilenceErrors:     true,
+	SilenceUsage:      true,
+	PersistentPreRunE: setup,
+	RunE:              runLegacy,
+}
+
+var powerCmd = &cobra.Command{
+	Use:   "power on|off [device|group]",
+	Short: "Switch a device or group on or off",
+	Example: `  tasmota-cli power on lamp
+  tasmota-cli power off downstairs
+  tasmota-cli power on powerstrip --relay 2 --verify`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		state := strings.ToLower(args[0])
+		if state != "on" && state != "off" {
+			return fmt.Errorf("power state \"%s\" is invalid, must be on or off", args[0])
+		}
+
+		devices, group, err := resolveTargets(args[1:])
+		if err != nil {
+			return err
+		}
+		return runPower(devices, state, group)
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status [device|group]",
+	Short: "Display the power state of a device or group, or the full status of a device",
+	Example: `  tasmota-cli status lamp
+  tasmota-cli status downstairs
+  tasmota-cli status lamp --all`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if viper.GetBool("all") {
+			dev, err := resolveSingle(args)
+			if err != nil {
+				return err
+			}
+			return runStatusAll(dev)
+		}
+
+		devices, group, err := resolveTargets(args)
+		if err != nil {
+			return err
+		}
+		return runPower(devices, "status", group)
+	},
+}
+
+var timersCmd = &cobra.Command{
+	Use:   "timers",
+	Short: "Manage the timers of a device",
+}
+
+var timersListCmd = &cobra.Command{
+	Use:     "list [device]",
+	Short:   "Display the timers of a device",
+	Example: `  tasmota-cli timers list lamp`,
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dev, err := resolveSingle(args)
+		if err != nil {
+			return err
+		}
+		return runTimers(dev)
+	},
+}
+
+var sendCmd = &cobra.Command{
+	Use:   "send [device] command",
+	Short: "Send any tasmota command to a device",
+	Long: `Send any tasmota command to a device and display the response.
+
+The device can be left out when using --device or --host.`,
+	Example: `  tasmota-cli send lamp 'Status 8'
+  tasmota-cli send lamp Backlog Power On; Dimmer 50
+  tasmota-cli send --host 172.28.10.12 Power`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// without --device or --host the first argument is the device
+		var target []string
+		if !viper.IsSet("device") && !viper.IsSet("host") {
+			if len(args) < 2 {
+				return fmt.Errorf("a device and a command are needed")
+			}
+			target, args = args[:1], args[1:]
+		}
+
+		dev, err := resolveSingle(target)
+		if err != nil {
+			return err
+		}
+		return runSend(dev, strings.Join(args, " "))
+	},
+}
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "List all configured devices",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return displayDevices()
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Display the configuration",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return displayConfig()
+	},
+}
+
+var consoleCmd = &cobra.Command{
+	Use:   "console [device]",
+	Short: "Interactive console for sending commands to a device",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dev, err := resolveSingle(args)
+		if err != nil {
+			return err
+		}
+		return runConsole(dev)
+	},
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [device]",
+	Short: "Display the log of a device",
+	Example: `  tasmota-cli logs lamp
+  tasmota-cli logs lamp --follow --level 4`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dev, err := resolveSingle(args)
+		if err != nil {
+			return err
+		}
+		return runLogs(dev)
+	},
+}
+
+var syslogCmd = &cobra.Command{
+	Use:   "syslog-server [device|group]",
+	Short: "Receive syslog messages from devices",
+	Long: `Receive syslog messages from devices, printing them or writing them to per device files.
+
+With --configure the LogHost and LogPort of the given device or group, or all configured
+devices, are pointed at this server. A device that can't be configured stops the server, except
+in a group, where the server carries on and exits with the error once it is stopped.`,
+	Example: `  tasmota-cli syslog-server --listen :5140 --logdir /var/log/tasmota --configure`,
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSyslogServer(args)
+	},
+}
+
+func init() {
+	homeDirName, _ = os.UserHomeDir()
+
+	rootCmd.SetVersionTemplate(applicationName + " " + applicationVersion + "\n")
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+
+	// global flags
+	global := rootCmd.PersistentFlags()
+	global.String("config", homeDirName+"/.tascli", "Configuration file: /path/to/file.yaml")
+	global.String("device", "", "Name of device")
+	global.String("group", "", "Name of a group of devices")
+	global.String("host", "", "IP address or hostname of device")
+	global.String("profile", "", "Profile of the configuration to use")
+	global.String("output", "", "Output format: "+strings.Join(outputFormats, ", "))
+	global.Bool("json", false, "Output JSON, same as --output json")
+	global.String("format", "", "Format the output using a Go template, e.g. '{{.StatusNET.IPAddress}}'")
+	global.String("select", "", "Select fields from the response, e.g. StatusSNS.ENERGY.Power")
+	global.Duration("timeout", 5*time.Second, "Time to wait for a device to respond")
+	global.Int("retries", 0, "Number of times to retry a failed request")
+	global.Duration("retry-backoff", 500*time.Millisecond, "Time to wait before the first retry, doubling each retry")
+	global.Bool("retry-writes", false, "Also retry commands that change state, such as power on")
+	global.Bool("verbose", false, "Be verbose")
+	global.Bool("nagios", false, "Output a single line as nagios plugins do, with status, health and send")
+	global.StringArrayP("warning", "w", nil, "Warning range of a field with --nagios, e.g. StatusSTS.Wifi.RSSI=40:")
+	global.StringArrayP("critical", "c", nil, "Critical range of a field with --nagios, e.g. StatusSNS.ENERGY.Power=2000")
+	global.String("record", "", "Record every request to devices and their responses to a HAR file")
+	global.String("replay", "", "Answer requests with the responses recorded by --record instead of asking devices")
+
+	// old flags, kept so existing scripts keep working
+	legacy := rootCmd.Flags()
+	legacy.String("cmd", "", "Command: on, off, status, statusall, timers")
+	legacy.String("custom", "", "Custom escaped command string to send")
+	legacy.Bool("displayconfig", false, "Display configuration")
+	legacy.Bool("list", false, "List all configured devices")
+	legacy.MarkDeprecated("cmd", "use the power, status or timers commands instead")
+	legacy.MarkDeprecated("custom", "use the send command instead")
+	legacy.MarkDeprecated("displayconfig", "use the config show command instead")
+	legacy.MarkDeprecated("list", "use the devices command instead")
+	legacy.Bool("all-profiles", false, "List the devices of every profile, with --list")
+	legacy.MarkHidden("all-profiles")
+	addPowerFlags(legacy)
+	for _, name := range []string{"relay", "verify", "verify-attempts", "verify-delay"} {
+		legacy.MarkHidden(name)
+	}
+
+	devicesCmd.Flags().Bool("all-profiles", false, "List the devices of every profile")
+
+	addPowerFlags(powerCmd.Flags())
+	addPowerFlags(statusCmd.Flags())
+	statusCmd.Flags().Bool("all", false, "Display the full status of a device")
+
+	logsCmd.Flags().Bool("follow", false, "Keep polling for new log lines")
+	logsCmd.Flags().Int("level", 0, "Web log level to use while displaying logs: 0-4")
+
+	syslogCmd.Flags().String("listen", ":514", "Address to listen on")
+	syslogCmd.Flags().String("logdir", "", "Directory to write per device log files to")
+	syslogCmd.Flags().Bool("configure", false, "Point the LogHost and LogPort of devices at this server")
+	syslogCmd.Flags().Int("level", 2, "Syslog level to set on devices when configuring: 0-4")
+
+	timersCmd.AddCommand(timersListCmd)
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(powerCmd, statusCmd, timersCmd, sendCmd, devicesCmd, configCmd, consoleCmd, logsCmd, syslogCmd)
+}
+
+// flags for power commands
+func addPowerFlags(flags *pflag.FlagSet) {
+	flags.Int("relay", 0, "Relay number on devices with multiple relays")
+	flags.Bool("verify", false, "Check the device reached the requested power state, retrying if it didn't")
+	flags.Int("verify-attempts", 3, "Number of times to send a power command when verifying")
+	flags.Duration("verify-delay", time.Second, "Time to wait before reading back the power state when verifying")
+}
+
+// keep a flag from being bound to viper, so it is read with cmd.Flags() instead
+func unbindFlag(flags *pflag.FlagSet, name string) {
+	flags.SetAnnotation(name, unboundFlag, []string{"true"})
+}
+
+// bind flags to viper, other than those kept from it by unbindFlag
+func bindFlags(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if _, unbound := f.Annotations[unboundFlag]; !unbound && err == nil {
+			err = viper.BindPFlag(f.Name, f)
+		}
+	})
+	return err
+}
+
+// bind the flags of the command being run and load the configuration
+func setup(cmd *cobra.Command, args []string) error {
+	// completions load the configuration themselves, ignoring any errors
+	if cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd {
+		return nil
+	}
+
+	activeFlags = cmd.Flags()
+	if err := bindFlags(cmd.Flags()); err != nil {
+		return err
+	}
+
+	viper.SetEnvPrefix("TASCLI")
+	if err := viper.BindEnv("config"); err != nil {
+		return err
+	}
+	if err := viper.BindEnv("profile"); err != nil {
+		return err
+	}
+
+	// temp
+	verbose = viper.GetBool("verbose")
+
+	if err := checkOutputFlags(); err != nil {
+		return err
+	}
+	if err := checkPluginFlags(cmd.Name()); err != nil {
+		return err
+	}
+	if err := setupSession(); err != nil {
+		return err
+	}
+
+	err := loadConfig()
+
+	// config commands are how problems get fixed, and can create a missing file
+	if cmd.Parent() == configCmd {
+		var notFound *ConfigNotFoundError
+		if err != nil && !errors.As(err, &notFound) {
+			return err
+		}
+		// changes are made to the profile itself, only showing needs it applied
+		if cmd == configShowCmd {
+			return applyProfile()
+		}
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+	if err := checkConfig(); err != nil {
+		return err
+	}
+	return applyProfile()
+}
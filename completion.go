@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish",
+	Short: "Generate a shell completion script",
+	Long: `Generate a shell completion script, completing commands, flags and the device and group
+names in the configuration file.
+
+To load completions:
+
+  bash:  source <(tasmota-cli completion bash)
+  zsh:   tasmota-cli completion zsh > "${fpath[1]}/_tasmota-cli"
+  fish:  tasmota-cli completion fish > ~/.config/fish/completions/tasmota-cli.fish`,
+	ValidArgs:             []string{"bash", "zsh", "fish"},
+	Args:                  cobra.ExactValidArgs(1),
+	DisableFlagsInUseLine: true,
+	// generating a script doesn't need the configuration
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		}
+		return fmt.Errorf("shell \"%s\" is not supported, must be bash, zsh or fish", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+
+	rootCmd.RegisterFlagCompletionFunc("device", completeDevices)
+	rootCmd.RegisterFlagCompletionFunc("group", completeGroups)
+	rootCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+	rootCmd.RegisterFlagCompletionFunc("output", fixedCompletion(outputFormats...))
+	rootCmd.RegisterFlagCompletionFunc("cmd", fixedCompletion(commandNames()...))
+
+	powerCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		switch len(args) {
+		case 0:
+			return []string{"on", "off"}, cobra.ShellCompDirectiveNoFileComp
+		case 1:
+			return completeTargets(cmd, args, toComplete)
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	statusCmd.ValidArgsFunction = firstArg(completeTargets)
+	healthCmd.ValidArgsFunction = firstArg(completeTargets)
+	wifiCmd.ValidArgsFunction = firstArg(completeTargets)
+	networkSetCmd.ValidArgsFunction = firstArg(completeTargets)
+	mqttShowCmd.ValidArgsFunction = firstArg(completeTargets)
+	mqttSetCmd.ValidArgsFunction = firstArg(completeTargets)
+	mqttAuditCmd.ValidArgsFunction = firstArg(completeTargets)
+	auditCmd.ValidArgsFunction = firstArg(completeTargets)
+	resolveCmd.ValidArgsFunction = firstArg(completeTargets)
+	syslogCmd.ValidArgsFunction = firstArg(completeTargets)
+	timersListCmd.ValidArgsFunction = firstArg(completeDevices)
+	consoleCmd.ValidArgsFunction = firstArg(completeDevices)
+	logsCmd.ValidArgsFunction = firstArg(completeDevices)
+	configRmDeviceCmd.ValidArgsFunction = firstArg(completeDevices)
+	configRenameDeviceCmd.ValidArgsFunction = firstArg(completeDevices)
+	sendCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 || flagChanged("device") || flagChanged("host") {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completeDevices(cmd, args, toComplete)
+	}
+}
+
+// complete configured device names
+func completeDevices(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	completionConfig(cmd)
+	return deviceNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+// complete configured group names
+func completeGroups(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	completionConfig(cmd)
+	return sortedKeys(viper.GetStringMap("groups")), cobra.ShellCompDirectiveNoFileComp
+}
+
+// complete configured profile names
+func completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	completionConfig(cmd)
+	return profileNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+// complete configured device and group names
+func completeTargets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	devices, _ := completeDevices(cmd, args, toComplete)
+	groups, _ := completeGroups(cmd, args, toComplete)
+	return append(devices, groups...), cobra.ShellCompDirectiveNoFileComp
+}
+
+// only complete the first argument of a command
+func firstArg(complete func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective)) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return complete(cmd, args, toComplete)
+	}
+}
+
+// complete a fixed list of values
+func fixedCompletion(values ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// sorted list of --cmd commands
+func commandNames() []string {
+	var names []string
+	for name := range commandList {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// load the configuration while completing, where commands don't run and errors can't be shown
+func completionConfig(cmd *cobra.Command) {
+	activeFlags = cmd.Flags()
+	bindFlags(cmd.Flags())
+	viper.SetEnvPrefix("TASCLI")
+	viper.BindEnv("config")
+	viper.BindEnv("profile")
+	loadConfig()
+	applyProfile()
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// a label in a hostname, see rfc 1123
+var hostnameLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+var configAddDeviceCmd = &cobra.Command{
+	Use:     "add-device name host",
+	Short:   "Add a device to the configuration",
+	Example: `  tasmota-cli config add-device lamp 172.28.10.12 --tag downstairs --tag lights`,
+	Args:    cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tags, err := cmd.Flags().GetStringSlice("tag")
+		if err != nil {
+			return err
+		}
+
+		name, host := args[0], args[1]
+		err = editConfig(func(c *configFile) error {
+			if err := checkDeviceName(c, name); err != nil {
+				return err
+			}
+			if err := checkHost(host); err != nil {
+				return err
+			}
+
+			// a plain address unless there is more to say about the device
+			value := scalarNode(host)
+			if len(tags) > 0 {
+				value = &yaml.Node{Kind: yaml.MappingNode}
+				setKey(value, "host", scalarNode(host))
+				list := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+				for _, tag := range tags {
+					list.Content = append(list.Content, scalarNode(tag))
+				}
+				setKey(value, "tags", list)
+			}
+
+			setKey(c.section("devices"), name, value)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Added %s (%s)\n", name, host)
+		return nil
+	},
+}
+
+var configRmDeviceCmd = &cobra.Command{
+	Use:   "rm-device name",
+	Short: "Remove a device from the configuration and any groups it is in",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		var groups []configGroup
+		err := editConfig(func(c *configFile) error {
+			key, _ := findDevice(c, name)
+			if key == nil {
+				return fmt.Errorf("device %s not found", name)
+			}
+			name = key.Value
+			deleteKey(c.section("devices"), name)
+
+			groups = c.groupsWith(name)
+			for _, group := range groups {
+				removeItem(group.members, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, group := range groups {
+			fmt.Printf("Removed %s from group %s\n", name, group.name)
+		}
+		fmt.Printf("Removed %s\n", name)
+		return nil
+	},
+}
+
+var configRenameDeviceCmd = &cobra.Command{
+	Use:   "rename-device old new",
+	Short: "Rename a device, including in any groups it is in",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		old, name := args[0], args[1]
+		var groups []configGroup
+		err := editConfig(func(c *configFile) error {
+			key, _ := findDevice(c, old)
+			if key == nil {
+				return fmt.Errorf("device %s not found", old)
+			}
+			// changing only the case of a name doesn't clash with the device itself
+			if strings.EqualFold(old, name) {
+				if err := validDeviceName(name); err != nil {
+					return err
+				}
+			} else if err := checkDeviceName(c, name); err != nil {
+				return err
+			}
+			old = key.Value
+			key.Value = name
+
+			groups = c.groupsWith(old)
+			for _, group := range groups {
+				for _, member := range group.members.Content {
+					if strings.EqualFold(member.Value, old) {
+						member.Value = name
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, group := range groups {
+			fmt.Printf("Renamed %s in group %s\n", old, group.name)
+		}
+		fmt.Printf("Renamed %s to %s\n", old, name)
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set key value",
+	Short: "Set a value in the configuration",
+	Long: `Set a value in the configuration, using a dotted key for nested values.
+The value is read as yaml, so numbers and true or false keep their type.`,
+	Example: `  tasmota-cli config set timeout 10s
+  tasmota-cli config set devices.heater.retries 4`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := editConfig(func(c *configFile) error {
+			keys := strings.Split(args[0], ".")
+			if len(keys) > 1 && keys[0] == "devices" {
+				// devices are found ignoring case, as viper does, so Heater is changed by heater
+				if key, _ := findDevice(c, keys[1]); key != nil {
+					keys[1] = key.Value
+				}
+			}
+			node := c.scope()
+			for i, key := range keys[:len(keys)-1] {
+				_, value := findKey(node, key)
+				if value == nil {
+					child := &yaml.Node{Kind: yaml.MappingNode}
+					setKey(node, key, child)
+					node = child
+					continue
+				}
+
+				if value.Kind == yaml.ScalarNode && value.Value != "" && keys[0] == "devices" && i == 1 {
+					// a device given as just an address becomes a map so it can have settings
+					host := scalarNode(value.Value)
+					host.LineComment = value.LineComment
+					*value = yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{scalarNode("host"), host}}
+				}
+				if value.Kind != yaml.MappingNode {
+					return fmt.Errorf("%s is not a map", strings.Join(keys[:i+1], "."))
+				}
+				node = value
+			}
+
+			var value yaml.Node
+			if err := yaml.Unmarshal([]byte(args[1]), &value); err != nil || len(value.Content) == 0 {
+				value = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{scalarNode(args[1])}}
+			}
+			setKey(node, keys[len(keys)-1], value.Content[0])
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Set %s to %s\n", args[0], args[1])
+		return nil
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration for problems",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := openConfigFile()
+		if err != nil {
+			return err
+		}
+
+		all := validateConfig(c)
+		for _, p := range all {
+			if p.warning {
+				fmt.Printf("Warning: %s\n", p)
+			} else {
+				fmt.Println(p)
+			}
+		}
+		if problems, _ := splitWarnings(all); len(problems) > 0 {
+			return fmt.Errorf("%s has %s", c.path, countProblems(len(problems)))
+		}
+
+		fmt.Printf("%s is valid\n", c.path)
+		return nil
+	},
+}
+
+func init() {
+	configAddDeviceCmd.Flags().StringSlice("tag", nil, "Tag for the device, can be given more than once")
+
+	configCmd.AddCommand(configAddDeviceCmd, configRmDeviceCmd, configRenameDeviceCmd, configSetCmd, configValidateCmd)
+}
+
+// a configuration file loaded as yaml nodes, so it can be changed without losing comments or ordering
+type configFile struct {
+	path   string
+	doc    *yaml.Node
+	header bool // file starts with ---
+}
+
+// a group and the sequence node of its members
+type configGroup struct {
+	name    string
+	members *yaml.Node
+}
+
+// a problem found in the configuration file
+type configProblem struct {
+	path    string
+	line    int
+	message string
+	warning bool // doesn't stop the configuration being used, e.g. an unknown setting
+}
+
+func (p configProblem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.path, p.line, p.message)
+}
+
+// load the configuration file viper read
+func openConfigFile() (*configFile, error) {
+	// without a configuration file, changes create the one given by --config
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		path = viper.GetString("config")
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	c := &configFile{path: path, doc: &yaml.Node{}, header: bytes.HasPrefix(b, []byte("---"))}
+	if err := yaml.Unmarshal(b, c.doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	switch {
+	case c.doc.Kind == 0:
+		// an empty file, or one of only comments which yaml leaves out, so keep them as a header
+		c.doc = &yaml.Node{Kind: yaml.DocumentNode, HeadComment: strings.TrimSpace(string(b)), Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	case c.root().Kind == yaml.ScalarNode && c.root().Tag == "!!null":
+		// a document with nothing in it, such as ---
+		empty := c.root()
+		c.doc.Content[0] = &yaml.Node{Kind: yaml.MappingNode, HeadComment: empty.HeadComment, LineComment: empty.LineComment, FootComment: empty.FootComment}
+	}
+	return c, nil
+}
+
+// load the configuration file, change it and save it if it is still valid
+func editConfig(change func(c *configFile) error) error {
+	c, err := openConfigFile()
+	if err != nil {
+		return err
+	}
+
+	if c.root().Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: configuration must be a map", c.path)
+	}
+
+	// only refuse changes that add problems, so a broken file can still be fixed
+	existing := map[string]bool{}
+	for _, p := range validateConfig(c) {
+		existing[p.message] = true
+	}
+
+	if err := change(c); err != nil {
+		return err
+	}
+
+	var added []configProblem
+	problems, warnings := splitWarnings(validateConfig(c))
+	for _, p := range problems {
+		if !existing[p.message] {
+			added = append(added, p)
+		}
+	}
+	for _, w := range warnings {
+		if !existing[w.message] {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w.message)
+		}
+	}
+	if len(added) > 0 {
+		for _, p := range added {
+			fmt.Println(p.message)
+		}
+		return fmt.Errorf("not saving %s, the change would add %s", c.path, countProblems(len(added)))
+	}
+
+	return c.save()
+}
+
+// write the configuration file, replacing it in one go so it is never half written
+func (c *configFile) save() error {
+	var buf bytes.Buffer
+	if c.header {
+		buf.WriteString("---\n")
+	}
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(c.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".tascli-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// the top level mapping of the file
+func (c *configFile) root() *yaml.Node {
+	return c.doc.Content[0]
+}
+
+// the map changes are made to, which is the chosen profile or the top level
+func (c *configFile) scope() *yaml.Node {
+	name := profileName()
+	if name == "" {
+		return c.root()
+	}
+	profiles := mapIn(c.root(), "profiles")
+	for i := 0; i+1 < len(profiles.Content); i += 2 {
+		// profiles are chosen ignoring case, so --profile office changes Office
+		if strings.EqualFold(profiles.Content[i].Value, name) {
+			return mapIn(profiles, profiles.Content[i].Value)
+		}
+	}
+	return mapIn(profiles, name)
+}
+
+// a map such as devices or groups in the chosen profile or the top level, created if missing
+func (c *configFile) section(name string) *yaml.Node {
+	return mapIn(c.scope(), name)
+}
+
+// a map in a mapping node, created if missing
+func mapIn(m *yaml.Node, name string) *yaml.Node {
+	if _, value := findKey(m, name); value != nil {
+		if value.Kind != yaml.MappingNode && value.Value == "" {
+			*value = yaml.Node{Kind: yaml.MappingNode}
+		}
+		return value
+	}
+
+	section := &yaml.Node{Kind: yaml.MappingNode}
+	setKey(m, name, section)
+	return section
+}
+
+// groups that have a device as a member
+func (c *configFile) groupsWith(device string) []configGroup {
+	_, groups := findKey(c.scope(), "groups")
+	if groups == nil || groups.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var found []configGroup
+	for i := 0; i+1 < len(groups.Content); i += 2 {
+		members := groups.Content[i+1]
+		if members.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, member := range members.Content {
+			if strings.EqualFold(member.Value, device) {
+				found = append(found, configGroup{name: groups.Content[i].Value, members: members})
+				break
+			}
+		}
+	}
+	return found
+}
+
+// change the host of a configured device, which is either just an address or a map with a host
+func setDeviceHost(c *configFile, name, host string) error {
+	return setDeviceSetting(c, name, "host", host)
+}
+
+// change a setting of a configured device, a device given as just an address becomes a map with
+// a host so it can have other settings
+func setDeviceSetting(c *configFile, name, key, setting string) error {
+	_, value := findDevice(c, name)
+	if value == nil {
+		return fmt.Errorf("device %s not found", name)
+	}
+	if value.Kind != yaml.MappingNode {
+		if key == "host" {
+			value.Kind, value.Tag, value.Value = yaml.ScalarNode, "!!str", setting
+			return nil
+		}
+		host := scalarNode(value.Value)
+		value.Kind, value.Tag, value.Value, value.Style = yaml.MappingNode, "!!map", "", 0
+		value.Content = []*yaml.Node{scalarNode("host"), host}
+	}
+	setKey(value, key, scalarNode(setting))
+	return nil
+}
+
+// find a device in the chosen profile or the top level, returning its key and value nodes
+//
+// viper ignores case, so the device may be named differently in the file
+func findDevice(c *configFile, name string) (*yaml.Node, *yaml.Node) {
+	_, devices := findKey(c.scope(), "devices")
+	if devices == nil || devices.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(devices.Content); i += 2 {
+		if strings.EqualFold(devices.Content[i].Value, name) {
+			return devices.Content[i], devices.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// check a new device name is usable and not already taken
+func checkDeviceName(c *configFile, name string) error {
+	if err := validDeviceName(name); err != nil {
+		return err
+	}
+
+	// viper ignores case, so lamp and Lamp would be the same device
+	if key, _ := findDevice(c, name); key != nil {
+		return fmt.Errorf("device %s already exists", key.Value)
+	}
+	return nil
+}
+
+// check a device name can be used as a key by viper
+func validDeviceName(name string) error {
+	if name == "" || strings.ContainsAny(name, ". \t") {
+		return fmt.Errorf("device name \"%s\" is invalid, it can't be empty or contain dots or spaces", name)
+	}
+	return nil
+}
+
+// check a host is an ip address or hostname, with an optional port
+func checkHost(host string) error {
+	address := host
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if port == "" {
+			return fmt.Errorf("host \"%s\" has an empty port", host)
+		}
+		address = h
+	}
+
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+
+	labels := strings.Split(strings.TrimSuffix(address, "."), ".")
+	if address == "" || len(address) > 253 {
+		return fmt.Errorf("host \"%s\" is not a valid ip address or hostname", host)
+	}
+	for _, label := range labels {
+		if !hostnameLabel.MatchString(label) {
+			return fmt.Errorf("host \"%s\" is not a valid ip address or hostname", host)
+		}
+	}
+	return nil
+}
+
+// a plain string node
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// find a key in a mapping node, returning the key and value nodes
+func findKey(m *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i], m.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// set a key in a mapping node, keeping its place if it already exists
+func setKey(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			// keep any comments on the old value
+			value.HeadComment = m.Content[i+1].HeadComment
+			value.LineComment = m.Content[i+1].LineComment
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Style = 0
+	m.Content = append(m.Content, scalarNode(key), value)
+}
+
+// delete a key from a mapping node
+func deleteKey(m *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// remove every item matching ignoring case from a sequence node, as names such as devices are
+func removeItem(s *yaml.Node, value string) {
+	var kept []*yaml.Node
+	for _, item := range s.Content {
+		if !strings.EqualFold(item.Value, value) {
+			kept = append(kept, item)
+		}
+	}
+	s.Content = kept
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+const consoleHelp string = `Type any tasmota command, for example: Status 0, Power Toggle, Backlog Power On; Dimmer 50
+
+Console commands:
+  :use [name]      Switch to a configured device
+  :host [address]  Switch to a device by IP address or hostname
+  :list            List all configured devices
+  :help            Display this help
+  :quit            Exit the console`
+
+// puts the terminal back as it was, set while the console has it in raw mode so it is restored
+// however the cli exits
+var restoreTerminal = func() {}
+
+// reads lines from the console, with or without line editing
+type consoleReader interface {
+	ReadLine() (string, error)
+	SetPrompt(prompt string)
+}
+
+// reads lines from a pipe or file, used when stdin isn't a terminal
+type plainReader struct {
+	scanner *bufio.Scanner
+}
+
+func (p *plainReader) ReadLine() (string, error) {
+	if p.scanner.Scan() {
+		return p.scanner.Text(), nil
+	}
+	if err := p.scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+func (p *plainReader) SetPrompt(prompt string) {}
+
+// interactive console for sending raw commands to a device
+func runConsole(dev Device) error {
+	var reader consoleReader
+	var out io.Writer = os.Stdout
+
+	// use line editing and history when attached to a terminal
+	stdin := int(os.Stdin.Fd())
+	if term.IsTerminal(stdin) {
+		oldState, err := term.MakeRaw(stdin)
+		if err != nil {
+			return err
+		}
+		restore := func() { term.Restore(stdin, oldState) }
+		restoreTerminal = restore
+		defer func() {
+			restore()
+			restoreTerminal = func() {}
+		}()
+
+		// a raw terminal doesn't turn ctrl-c into a signal, but the cli can still be stopped by others
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, syscall.SIGHUP)
+		defer signal.Stop(stop)
+		go func() {
+			<-stop
+			restore()
+			os.Exit(exitError)
+		}()
+
+		terminal := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, "")
+		reader = terminal
+		out = terminal
+
+		// the terminal adds the carriage returns a raw terminal needs
+		verboseOut = terminal
+		defer func() { verboseOut = os.Stdout }()
+	} else {
+		reader = &plainReader{scanner: bufio.NewScanner(os.Stdin)}
+	}
+
+	fmt.Fprintf(out, "Connected to %s (%s), type :help for help\n", dev.Name, dev.Host)
+
+	for {
+		reader.SetPrompt(dev.Name + "> ")
+
+		line, err := reader.ReadLine()
+		if err == io.EOF {
+			fmt.Fprintln(out)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		// console commands
+		if strings.HasPrefix(line, ":") {
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case ":quit", ":exit", ":q":
+				return nil
+			case ":help":
+				fmt.Fprintln(out, consoleHelp)
+			case ":list":
+				fmt.Fprintln(out, strings.Join(deviceNames(), "\n"))
+			case ":use":
+				if len(fields) != 2 {
+					fmt.Fprintln(out, "Usage: :use [name]")
+					continue
+				}
+				next, err := getDevice(fields[1])
+				if err != nil {
+					fmt.Fprintf(out, "Error: %s\n", err)
+					continue
+				}
+				dev = next
+				fmt.Fprintf(out, "Using %s (%s)\n", dev.Name, dev.Host)
+			case ":host":
+				if len(fields) != 2 {
+					fmt.Fprintln(out, "Usage: :host [address]")
+					continue
+				}
+				dev = hostDevice(fields[1])
+				fmt.Fprintf(out, "Using %s\n", dev.Host)
+			default:
+				fmt.Fprintf(out, "Unknown console command \"%s\", type :help for help\n", fields[0])
+			}
+			continue
+		}
+
+		// send the raw command to tasmota
+		response, err := sendTasmota(dev, url.QueryEscape(line))
+		if err != nil {
+			fmt.Fprintf(out, "Error: %s\n", err)
+			continue
+		}
+
+		o, err := customOutput(response)
+		if err == nil {
+			err = render(out, o)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "Error: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// a device and the settings used to talk to it
+type Device struct {
+	Name         string
+	Host         string
+	Timeout      time.Duration
+	Retries      int
+	RetryBackoff time.Duration
+	User         string
+	Password     *secret // nil when the device has no password
+	Mac          string  // empty when the mac address isn't known
+}
+
+// a device given by address only, using the global settings
+func hostDevice(host string) Device {
+	return Device{
+		Name:         host,
+		Host:         host,
+		Timeout:      globalDuration("timeout"),
+		Retries:      viper.GetInt("retries"),
+		RetryBackoff: globalDuration("retry-backoff"),
+		User:         globalUser(),
+		Password:     globalSecret("password"),
+	}
+}
+
+// user for devices with a password, tasmota always uses admin unless built otherwise
+func globalUser() string {
+	if viper.IsSet("user") {
+		return viper.GetString("user")
+	}
+	return "admin"
+}
+
+// get a configured device, which is either just an address:
+//
+//	lamp: 172.28.10.12
+//
+// or an address with settings that override the global settings:
+//
+//	lamp:
+//	  host: 172.28.10.12
+//	  timeout: 10s
+//	  retries: 3
+//	  retry-backoff: 1s
+//	  user: admin
+//	  password_cmd: pass show tasmota/lamp
+//	  mac: DC:4F:22:12:34:56
+func getDevice(name string) (Device, error) {
+	// viper keys are lower case
+	name = strings.ToLower(name)
+	entry, ok := viper.GetStringMap("devices")[name]
+	if !ok {
+		return Device{}, fmt.Errorf("device %s not found", name)
+	}
+
+	switch v := entry.(type) {
+	case string:
+		dev := hostDevice(v)
+		dev.Name = name
+		return dev, nil
+
+	case map[string]interface{}:
+		host, ok := v["host"].(string)
+		if !ok || host == "" {
+			return Device{}, fmt.Errorf("device %s has no host", name)
+		}
+		dev := hostDevice(host)
+		dev.Name = name
+
+		// command line flags take priority over the device settings
+		if value, ok := v["timeout"]; ok && !flagChanged("timeout") {
+			d, err := toDuration(value)
+			if err != nil {
+				return Device{}, fmt.Errorf("device %s has an invalid timeout: %s", name, err)
+			}
+			dev.Timeout = d
+		}
+		if value, ok := v["retries"]; ok && !flagChanged("retries") {
+			n, err := cast.ToIntE(value)
+			if err != nil {
+				return Device{}, fmt.Errorf("device %s has invalid retries: %s", name, err)
+			}
+			dev.Retries = n
+		}
+		if value, ok := v["retry-backoff"]; ok && !flagChanged("retry-backoff") {
+			d, err := toDuration(value)
+			if err != nil {
+				return Device{}, fmt.Errorf("device %s has an invalid retry-backoff: %s", name, err)
+			}
+			dev.RetryBackoff = d
+		}
+		if value, ok := v["user"]; ok {
+			dev.User = cast.ToString(value)
+		}
+		if value, ok := v["mac"]; ok {
+			dev.Mac = cast.ToString(value)
+		}
+		if password := secretFrom(v, "password"); password != nil {
+			dev.Password = password
+		}
+		return dev, nil
+	}
+
+	return Device{}, fmt.Errorf("device %s must be an address or a map with a host", name)
+}
+
+// get the devices in a configured group:
+//
+//	groups:
+//	  downstairs: [lamp, large]
+func getGroup(name string) ([]Device, error) {
+	name = strings.ToLower(name)
+	entry, ok := viper.GetStringMap("groups")[name]
+	if !ok {
+		return nil, fmt.Errorf("group %s not found", name)
+	}
+
+	members, err := cast.ToStringSliceE(entry)
+	if err != nil {
+		return nil, fmt.Errorf("group %s must be a list of device names", name)
+	}
+
+	var devices []Device
+	for _, member := range members {
+		dev, err := getDevice(member)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %s", name, err)
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+// check if a device is in the configuration rather than given by its address
+func isConfigured(name string) bool {
+	// viper keys are lower case
+	_, ok := viper.GetStringMap("devices")[strings.ToLower(name)]
+	return ok
+}
+
+// sorted list of configured device names
+func deviceNames() []string {
+	return sortedKeys(viper.GetStringMap("devices"))
+}
+
+// resolve the devices to act on from a device, group or address argument, or from
+// --device, --host or --group, reporting whether the target was a group
+func resolveTargets(args []string) ([]Device, bool, error) {
+	if len(args) > 0 {
+		if viper.IsSet("device") || viper.IsSet("host") || viper.IsSet("group") {
+			return nil, false, errors.New("give a device as an argument or with --device, --host or --group, not both")
+		}
+
+		name := args[0]
+		if _, ok := viper.GetStringMap("devices")[strings.ToLower(name)]; ok {
+			dev, err := getDevice(name)
+			return []Device{dev}, false, err
+		}
+		if _, ok := viper.GetStringMap("groups")[strings.ToLower(name)]; ok {
+			devices, err := getGroup(name)
+			return devices, true, err
+		}
+		if isAddress(name) {
+			return []Device{hostDevice(name)}, false, nil
+		}
+		return nil, false, fmt.Errorf("%s is not a configured device, group or ip address", name)
+	}
+
+	if (viper.IsSet("device")) && (viper.IsSet("host")) {
+		return nil, false, errors.New("--device and --host cannot be used at the same time")
+	}
+
+	if (viper.IsSet("group")) && (viper.IsSet("device") || viper.IsSet("host")) {
+		return nil, false, errors.New("--group cannot be used with --device or --host")
+	}
+
+	switch {
+	case viper.IsSet("group"):
+		devices, err := getGroup(viper.GetString("group"))
+		return devices, true, err
+	case viper.IsSet("host"):
+		return []Device{hostDevice(viper.GetString("host"))}, false, nil
+	case viper.IsSet("device"):
+		if verbose {
+			fmt.Printf("Device: %s\n", viper.GetString("device"))
+		}
+		dev, err := getDevice(viper.GetString("device"))
+		return []Device{dev}, false, err
+	}
+
+	return nil, false, errors.New("no device given, use a device name, --device or --host")
+}
+
+// resolve the devices to act on as resolveTargets does, or every configured device if no target
+// is given, which counts as a group
+func resolveTargetsOrAll(args []string) ([]Device, bool, error) {
+	if len(args) > 0 || viper.IsSet("device") || viper.IsSet("host") || viper.IsSet("group") {
+		return resolveTargets(args)
+	}
+
+	var devices []Device
+	for _, name := range deviceNames() {
+		dev, err := getDevice(name)
+		if err != nil {
+			return nil, false, err
+		}
+		devices = append(devices, dev)
+	}
+	return devices, true, nil
+}
+
+// most devices queried at once, so scanning a network doesn't run out of connections
+const maxQueries = 64
+
+// query devices at the same time, returning the error of each in the order given
+func queryDevices(devices []Device, query func(i int, dev Device) error) []error {
+	errs := make([]error, len(devices))
+	limit := make(chan struct{}, maxQueries)
+	var wg sync.WaitGroup
+	for i, dev := range devices {
+		wg.Add(1)
+		go func(i int, dev Device) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			errs[i] = query(i, dev)
+		}(i, dev)
+	}
+	wg.Wait()
+	return errs
+}
+
+// resolve a single device, for commands that can't act on a group
+func resolveSingle(args []string) (Device, error) {
+	devices, group, err := resolveTargets(args)
+	if err != nil {
+		return Device{}, err
+	}
+	if group {
+		return Device{}, errors.New("this command works on a single device, not a group")
+	}
+	return devices[0], nil
+}
+
+// check if a target is an ip address, with or without a port
+func isAddress(target string) bool {
+	host := target
+	if h, _, err := net.SplitHostPort(target); err == nil {
+		host = h
+	}
+	return net.ParseIP(host) != nil
+}
+
+// check if a flag was given on the command line
+func flagChanged(name string) bool {
+	if activeFlags == nil {
+		return false
+	}
+	f := activeFlags.Lookup(name)
+	return f != nil && f.Changed
+}
+
+// a duration from the command line or configuration file
+func globalDuration(key string) time.Duration {
+	d, err := toDuration(viper.Get(key))
+	if err != nil {
+		checkErr(fmt.Errorf("%s is invalid: %s", key, err))
+	}
+	return d
+}
+
+// convert a config value to a duration, plain numbers are seconds
+func toDuration(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	case string:
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(n * float64(time.Second)), nil
+		}
+	}
+	return cast.ToDurationE(value)
+}
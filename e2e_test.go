@@ -0,0 +1,1080 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// set in the environment of child processes, which run the cli instead of the tests
+const e2eMainEnv = "TASCLI_E2E_MAIN"
+
+// how long tests wait for devices and child processes
+const defaultTestTimeout = 5 * time.Second
+
+func TestMain(m *testing.M) {
+	if os.Getenv(e2eMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// simulated devices and a configuration file that uses them
+type testEnv struct {
+	t      *testing.T
+	home   string
+	config string
+	sims   map[string]*simulator
+	hosts  map[string]string
+}
+
+// output of a run of the cli
+type result struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+// start simulated devices lamp, strip with three relays and locked with a password, all in
+// group all except locked
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	e := &testEnv{
+		t:     t,
+		home:  t.TempDir(),
+		sims:  map[string]*simulator{},
+		hosts: map[string]string{},
+	}
+
+	devices := []struct {
+		name   string
+		relays int
+	}{{"lamp", 1}, {"strip", 3}, {"locked", 1}}
+
+	for _, d := range devices {
+		sim := newSimulator(d.name, d.relays)
+		server := httptest.NewServer(sim)
+		t.Cleanup(server.Close)
+		e.sims[d.name] = sim
+		e.hosts[d.name] = strings.TrimPrefix(server.URL, "http://")
+	}
+	e.sims["locked"].password = "s3cret"
+
+	e.config = filepath.Join(t.TempDir(), "tascli.yaml")
+	e.writeConfig(fmt.Sprintf(`# test devices
+timeout: 2s
+retry-backoff: 10ms
+devices:
+  lamp: %s
+  strip: %s
+  locked:
+    host: %s
+    password: s3cret
+groups:
+  all: [lamp, strip]
+`, e.hosts["lamp"], e.hosts["strip"], e.hosts["locked"]))
+
+	return e
+}
+
+func (e *testEnv) writeConfig(config string) {
+	e.t.Helper()
+	if err := os.WriteFile(e.config, []byte(config), 0600); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// the command for running the cli with the test configuration
+func (e *testEnv) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], append([]string{"--config", e.config}, args...)...)
+	cmd.Env = append(os.Environ(), e2eMainEnv+"=1", "HOME="+e.home, "XDG_CACHE_HOME="+filepath.Join(e.home, ".cache"), "TASCLI_CONFIG=", "TASCLI_PROFILE=")
+	return cmd
+}
+
+// run the cli and wait for it to finish
+func (e *testEnv) run(args ...string) result {
+	e.t.Helper()
+	return e.runInput("", args...)
+}
+
+// run the cli with something on stdin
+func (e *testEnv) runInput(input string, args ...string) result {
+	e.t.Helper()
+
+	cmd := e.command(args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	res := result{stdout: stdout.String(), stderr: stderr.String()}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		res.code = exitErr.ExitCode()
+	} else if err != nil {
+		e.t.Fatal(err)
+	}
+	return res
+}
+
+// run the cli, failing the test if it doesn't succeed
+func (e *testEnv) ok(args ...string) string {
+	e.t.Helper()
+
+	res := e.run(args...)
+	if res.code != 0 {
+		e.t.Fatalf("%s: exit code %d\nstdout: %s\nstderr: %s", strings.Join(args, " "), res.code, res.stdout, res.stderr)
+	}
+	return res.stdout
+}
+
+// start a long running command, returning it once a line of output contains want
+func (e *testEnv) start(want string, args ...string) (*exec.Cmd, string) {
+	e.t.Helper()
+
+	cmd := e.command(args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		e.t.Fatal(err)
+	}
+	e.t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	timeout := time.After(defaultTestTimeout)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				e.t.Fatalf("%s exited without printing %q", strings.Join(args, " "), want)
+			}
+			if strings.Contains(line, want) {
+				return cmd, line
+			}
+		case <-timeout:
+			e.t.Fatalf("%s didn't print %q", strings.Join(args, " "), want)
+		}
+	}
+}
+
+// wifi settings of a simulated device
+func (e *testEnv) wifi(device string) simWifi {
+	sim := e.sims[device]
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.wifi
+}
+
+// state of a relay of a simulated device
+func (e *testEnv) relay(device string, relay int) bool {
+	sim := e.sims[device]
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.power[relay-1]
+}
+
+func TestPowerCommand(t *testing.T) {
+	e := newTestEnv(t)
+
+	if out := e.ok("power", "on", "lamp"); out != "lamp:ON\n" {
+		t.Errorf("power on lamp = %q", out)
+	}
+	if !e.relay("lamp", 1) {
+		t.Error("lamp is off after power on")
+	}
+
+	if out := e.ok("power", "off", "lamp"); out != "lamp:OFF\n" {
+		t.Errorf("power off lamp = %q", out)
+	}
+	if e.relay("lamp", 1) {
+		t.Error("lamp is on after power off")
+	}
+
+	e.ok("power", "on", "strip", "--relay", "2", "--verify", "--verify-delay", "1ms")
+	if e.relay("strip", 1) || !e.relay("strip", 2) || e.relay("strip", 3) {
+		t.Error("power on strip --relay 2 switched the wrong relays")
+	}
+
+	out := e.ok("power", "on", "all")
+	if !strings.Contains(out, "lamp") || !strings.Contains(out, "strip") {
+		t.Errorf("power on all = %q, want both devices", out)
+	}
+	if !e.relay("lamp", 1) || !e.relay("strip", 1) {
+		t.Error("power on all didn't switch every device")
+	}
+
+	if res := e.run("power", "toggle", "lamp"); res.code != exitError {
+		t.Errorf("power toggle exit code = %d, want %d", res.code, exitError)
+	}
+}
+
+func TestStatusCommand(t *testing.T) {
+	e := newTestEnv(t)
+	e.ok("power", "on", "lamp")
+
+	if out := e.ok("status", "lamp"); out != "ON\n" {
+		t.Errorf("status lamp = %q, want ON", out)
+	}
+	if out := e.ok("status", "strip", "--relay", "3"); out != "OFF\n" {
+		t.Errorf("status strip --relay 3 = %q, want OFF", out)
+	}
+	if out := e.ok("status", e.hosts["lamp"]); out != "ON\n" {
+		t.Errorf("status by address = %q, want ON", out)
+	}
+
+	var results []PowerResult
+	if err := json.Unmarshal([]byte(e.ok("status", "all", "--output", "json")), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Power != "ON" || results[1].Power != "OFF" {
+		t.Errorf("status all = %+v", results)
+	}
+
+	if out := e.ok("status", "lamp", "--all", "--select", "Status.DeviceName"); out != "lamp\n" {
+		t.Errorf("status --all --select = %q, want lamp", out)
+	}
+	if out := e.ok("status", "lamp", "--all", "--select", "StatusSNS.ENERGY.Power"); out != "42\n" {
+		t.Errorf("status --all --select StatusSNS.ENERGY.Power = %q, want 42", out)
+	}
+	if out := e.ok("status", "all", "--select", "*.Status.DeviceName"); out != "lamp\nstrip\n" {
+		t.Errorf("status of a group --select = %q", out)
+	}
+	if out := e.ok("status", "lamp", "--select", "StatusNET.Hostname"); !strings.HasPrefix(out, "lamp-") {
+		t.Errorf("status --select = %q, want the hostname", out)
+	}
+	if out := e.ok("status", "lamp", "--all", "--output", "json"); !strings.Contains(out, `"ENERGY"`) {
+		t.Errorf("status --all --output json = %q, missing the energy sensor", out)
+	}
+	if out := e.ok("status", "lamp", "--all", "--format", "{{.StatusNET.Mac}}"); !strings.HasPrefix(out, "DC:4F:22") {
+		t.Errorf("status --all --format = %q, want the mac address", out)
+	}
+	if out := e.ok("status", "lamp", "--format", "{{.StatusNET.Mac}} {{.Status.Power}}"); !strings.HasPrefix(out, "DC:4F:22") || !strings.HasSuffix(out, " 1\n") {
+		t.Errorf("status --format = %q, want the mac address and power", out)
+	}
+	if out := e.ok("power", "off", "strip", "--relay", "2", "--format", "{{.POWER2}}"); out != "OFF\n" {
+		t.Errorf("power --format = %q, want OFF", out)
+	}
+	format := `{{range $name, $status := .}}{{$name}}={{$status.Status.DeviceName}} {{end}}`
+	if out := e.ok("status", "all", "--format", format); out != "lamp=lamp strip=strip \n" {
+		t.Errorf("status of a group --format = %q", out)
+	}
+
+	if res := e.run("status", "nope"); res.code != exitError || !strings.Contains(res.stderr, "nope") {
+		t.Errorf("status nope = %+v, want an error", res)
+	}
+}
+
+func TestTimersCommand(t *testing.T) {
+	e := newTestEnv(t)
+	e.ok("send", "lamp", `Timer3 {"Enable":1,"Time":"07:15"}`)
+
+	out := e.ok("timers", "list", "lamp")
+	if !strings.Contains(out, "Timer16") || !strings.Contains(out, "07:15") {
+		t.Errorf("timers list = %q", out)
+	}
+
+	var timers AllTimers
+	if err := json.Unmarshal([]byte(e.ok("timers", "list", "lamp", "--json")), &timers); err != nil {
+		t.Fatal(err)
+	}
+	if timers.Timer3.Enable != 1 || timers.Timer3.Time != "07:15" {
+		t.Errorf("Timer3 = %+v", timers.Timer3)
+	}
+}
+
+func TestSendCommand(t *testing.T) {
+	e := newTestEnv(t)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(e.ok("send", "lamp", "Backlog", "Power", "On;", "Dimmer", "40")), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["POWER"] != "ON" || got["Dimmer"] != 40.0 {
+		t.Errorf("send Backlog = %v", got)
+	}
+
+	if out := e.ok("send", "--host", e.hosts["lamp"], "Dimmer", "--select", "Dimmer"); out != "40\n" {
+		t.Errorf("send --host = %q, want 40", out)
+	}
+
+	if res := e.run("send", "lamp", "Foo"); res.code != exitUnknownCommand {
+		t.Errorf("send Foo exit code = %d, want %d", res.code, exitUnknownCommand)
+	}
+}
+
+func TestDevicesAndConfigCommands(t *testing.T) {
+	e := newTestEnv(t)
+
+	out := e.ok("devices")
+	for _, name := range []string{"lamp", "strip", "locked"} {
+		if !strings.Contains(out, name) {
+			t.Errorf("devices = %q, missing %s", out, name)
+		}
+	}
+
+	if out := e.ok("config", "show"); strings.Contains(out, "s3cret") || !strings.Contains(out, secretMask) {
+		t.Errorf("config show didn't mask the password: %q", out)
+	}
+
+	e.ok("config", "add-device", "plug", "10.0.0.5", "--tag", "kitchen")
+	e.ok("config", "rename-device", "lamp", "lounge")
+	e.ok("config", "set", "retries", "2")
+	e.ok("config", "rm-device", "strip")
+	e.ok("config", "validate")
+
+	b, err := os.ReadFile(e.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := string(b)
+	for _, want := range []string{"# test devices", "plug:", "tags: [kitchen]", "lounge:", "retries: 2", "all: [lounge]"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("config is missing %q:\n%s", want, config)
+		}
+	}
+	if strings.Contains(config, "strip") {
+		t.Errorf("config still has strip:\n%s", config)
+	}
+
+	if res := e.run("config", "add-device", "bad", "not a host"); res.code != exitError {
+		t.Errorf("add-device with a bad host exit code = %d, want %d", res.code, exitError)
+	}
+
+	e.writeConfig("retries: lots\ndevices:\n  lamp: " + e.hosts["lamp"] + "\n")
+	if res := e.run("status", "lamp"); res.code != exitError || !strings.Contains(res.stderr, ":1: retries must be a whole number") {
+		t.Errorf("status with a bad config = %+v, want the problem and its line", res)
+	}
+
+	// unknown settings are only warnings, while a bad value for a known one is still an error
+	e.writeConfig("notes: hello\ndevices:\n  lamp:\n    host: " + e.hosts["lamp"] + "\n    room: lounge\n")
+	if res := e.run("status", "lamp"); res.code != 0 || !strings.Contains(res.stderr, "Warning: "+e.config+":1: unknown setting notes") ||
+		!strings.Contains(res.stderr, ":5: device lamp has unknown setting room") {
+		t.Errorf("status with unknown settings = %+v, want warnings", res)
+	}
+	if out := e.ok("config", "validate"); !strings.Contains(out, "Warning: "+e.config+":1: unknown setting notes") || !strings.Contains(out, "is valid") {
+		t.Errorf("config validate with unknown settings = %q", out)
+	}
+	e.ok("config", "set", "retries", "2")
+	if res := e.run("config", "set", "retries", "lots"); res.code != exitError {
+		t.Errorf("config set with a bad value exit code = %d, want %d", res.code, exitError)
+	}
+
+	// names are matched ignoring case, as viper does
+	e.writeConfig("devices:\n  Lamp: " + e.hosts["lamp"] + "\n  Strip: " + e.hosts["strip"] + "\ngroups:\n  all: [Lamp, Strip]\n")
+	if res := e.run("config", "add-device", "lamp", "10.0.0.5"); res.code != exitError || !strings.Contains(res.stderr, "device Lamp already exists") {
+		t.Errorf("add-device of a name in another case = %+v", res)
+	}
+	if out := e.ok("power", "on", "ALL"); !strings.Contains(out, "lamp:ON") || !strings.Contains(out, "strip:ON") {
+		t.Errorf("power on a group of devices named in another case = %q", out)
+	}
+	e.ok("config", "rename-device", "lamp", "LAMP")
+	e.ok("config", "rm-device", "strip")
+	e.ok("config", "set", "devices.lamp.retries", "4")
+	if b, _ := os.ReadFile(e.config); strings.Contains(string(b), "Strip") || !strings.Contains(string(b), "all: [LAMP]") ||
+		!strings.Contains(string(b), "LAMP:\n    host: "+e.hosts["lamp"]+"\n    retries: 4\n") || strings.Count(string(b), "host:") != 1 {
+		t.Errorf("config after changing devices by another case:\n%s", b)
+	}
+}
+
+func TestConfigWithoutSettings(t *testing.T) {
+	e := newTestEnv(t)
+
+	for _, config := range []string{"", "---\n", "# my devices\n\n# more to come\n"} {
+		e.writeConfig(config)
+		e.ok("config", "add-device", "x", "1.2.3.4")
+		e.ok("config", "validate")
+		if out := e.ok("devices"); !strings.Contains(out, "1.2.3.4") {
+			t.Errorf("devices after adding to %q = %q", config, out)
+		}
+
+		b, err := os.ReadFile(e.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.TrimSuffix(config, "\n") + "\n"
+		if config == "" {
+			want = ""
+		} else if strings.HasPrefix(config, "#") {
+			want += "\n"
+		}
+		if !strings.HasPrefix(string(b), want) || !strings.Contains(string(b), "devices:\n  x: 1.2.3.4\n") {
+			t.Errorf("config after adding to %q:\n%s", config, b)
+		}
+	}
+
+	e.writeConfig("just words\n")
+	if res := e.run("config", "add-device", "x", "1.2.3.4"); res.code != exitError {
+		t.Errorf("add-device to a config that isn't a map = %+v", res)
+	}
+	if b, _ := os.ReadFile(e.config); string(b) != "just words\n" {
+		t.Errorf("add-device changed a config that isn't a map:\n%s", b)
+	}
+}
+
+func TestProfileNames(t *testing.T) {
+	e := newTestEnv(t)
+
+	// profiles are chosen ignoring case, like the rest of the configuration
+	e.writeConfig("profiles:\n  Office:\n    devices:\n      lamp: " + e.hosts["lamp"] + "\n")
+	if out := e.ok("--profile", "office", "power", "on", "lamp"); out != "lamp:ON\n" {
+		t.Errorf("power on lamp in profile office = %q", out)
+	}
+	e.ok("--profile", "OFFICE", "config", "add-device", "plug", "10.0.0.5")
+	b, err := os.ReadFile(e.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config := string(b); strings.Contains(config, "office:") || !strings.Contains(config, "plug: 10.0.0.5") {
+		t.Errorf("config after adding to profile OFFICE:\n%s", config)
+	}
+
+	e.writeConfig("profiles:\n  Office: {}\n  office: {}\n")
+	if res := e.run("config", "validate"); res.code != exitError || !strings.Contains(res.stdout, ":3: profile office is already defined on line 2") {
+		t.Errorf("config validate with a profile given twice = %+v", res)
+	}
+}
+
+func TestConsoleCommand(t *testing.T) {
+	e := newTestEnv(t)
+
+	res := e.runInput("Power On\n:use Strip\nPower2 On\n:quit\n", "console", "lamp")
+	if res.code != 0 {
+		t.Fatalf("console exit code = %d: %s", res.code, res.stderr)
+	}
+	if !strings.Contains(res.stdout, `"POWER": "ON"`) || !strings.Contains(res.stdout, "Using strip (") || !strings.Contains(res.stdout, `"POWER2": "ON"`) {
+		t.Errorf("console output = %q", res.stdout)
+	}
+	if !e.relay("lamp", 1) || !e.relay("strip", 2) {
+		t.Error("console commands didn't switch the relays")
+	}
+}
+
+func TestLogsCommand(t *testing.T) {
+	e := newTestEnv(t)
+	e.ok("power", "on", "lamp")
+
+	out := e.ok("logs", "lamp")
+	if !strings.Contains(out, "CMD: Power On") || !strings.Contains(out, `RSL: RESULT = {"POWER":"ON"}`) {
+		t.Errorf("logs = %q", out)
+	}
+
+	e.ok("logs", "lamp", "--level", "4")
+	sim := e.sims["lamp"]
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if sim.webLog != 2 {
+		t.Errorf("WebLog = %d after logs --level, want it restored to 2", sim.webLog)
+	}
+}
+
+func TestSyslogServerCommand(t *testing.T) {
+	e := newTestEnv(t)
+
+	_, line := e.start("Listening", "syslog-server", "--listen", "127.0.0.1:0", "--configure", "lamp")
+	_, port, err := net.SplitHostPort(line[strings.LastIndex(line, " ")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim := e.sims["lamp"]
+	sim.mu.Lock()
+	if sim.logHost != "127.0.0.1" || fmt.Sprint(sim.logPort) != port || sim.sysLog != 2 {
+		t.Errorf("LogHost %s, LogPort %d, SysLog %d, want 127.0.0.1, %s, 2", sim.logHost, sim.logPort, sim.sysLog, port)
+	}
+	sim.mu.Unlock()
+
+	if res := e.run("syslog-server", "--listen", "127.0.0.1:0", "--configure", "--host", "127.0.0.1:1"); res.code == 0 || !strings.Contains(res.stderr, "could not connect") {
+		t.Errorf("syslog-server --configure of a device it can't reach = %+v", res)
+	}
+
+	// the rest of a group is still listened to, and the failure decides the exit code once stopped
+	e.writeConfig(fmt.Sprintf("devices:\n  lamp: %s\n  gone: 127.0.0.1:1\n", e.hosts["lamp"]))
+	cmd, _ := e.start("Listening", "syslog-server", "--listen", "127.0.0.1:0", "--configure")
+	cmd.Process.Signal(syscall.SIGTERM)
+	if err := cmd.Wait(); cmd.ProcessState.ExitCode() == 0 {
+		t.Errorf("syslog-server --configure of a group with a device it can't reach exited with %v", err)
+	}
+}
+
+func TestSimulateCommand(t *testing.T) {
+	e := newTestEnv(t)
+
+	_, line := e.start("Simulating", "simulate", "--listen", "127.0.0.1:0", "--relays", "2", "--name", "fake")
+	host := line[strings.LastIndex(line, " ")+1:]
+
+	e.ok("power", "on", host, "--relay", "2")
+	if out := e.ok("status", host, "--all", "--select", "Status.Power"); out != "2\n" {
+		t.Errorf("Status.Power = %q, want 2", out)
+	}
+}
+
+func TestCompletionCommand(t *testing.T) {
+	e := newTestEnv(t)
+
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		if out := e.ok("completion", shell); !strings.Contains(out, "tasmota-cli") {
+			t.Errorf("completion %s = %q", shell, out)
+		}
+	}
+
+	out := e.ok("__complete", "--config", e.config, "power", "on", "")
+	for _, want := range []string{"lamp", "strip", "all"} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("completing power on = %q, missing %s", out, want)
+		}
+	}
+}
+
+func TestLegacyFlags(t *testing.T) {
+	e := newTestEnv(t)
+
+	res := e.run("--cmd", "on", "--device", "lamp")
+	if res.code != 0 || res.stdout != "lamp:ON\n" || !strings.Contains(res.stderr, "deprecated") {
+		t.Errorf("--cmd on = %+v", res)
+	}
+
+	if out := e.ok("--custom", "Power", "--device", "lamp", "--output", "raw"); out != `{"POWER":"ON"}`+"\n" {
+		t.Errorf("--custom = %q", out)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	e := newTestEnv(t)
+
+	if out := e.ok("status", "locked"); out != "OFF\n" {
+		t.Errorf("status with a password = %q, want OFF", out)
+	}
+	if res := e.run("status", "--host", e.hosts["locked"]); res.code != exitAuthRequired {
+		t.Errorf("status without a password exit code = %d, want %d", res.code, exitAuthRequired)
+	}
+
+	// nothing listens on a closed listener's address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+
+	res := e.run("status", "--host", closed, "--output", "json")
+	if res.code != exitUnreachable {
+		t.Errorf("unreachable exit code = %d, want %d", res.code, exitUnreachable)
+	}
+	var response ErrorResponse
+	if err := json.Unmarshal([]byte(res.stdout), &response); err != nil || response.Error.Kind != "unreachable" {
+		t.Errorf("unreachable json error = %q", res.stdout)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	e := newTestEnv(t)
+	dir := t.TempDir()
+
+	status := e.ok("--record", filepath.Join(dir, "status.har"), "status", "locked", "--all")
+	// the simulator doesn't know WebPassword, but the command is recorded all the same
+	e.run("--record", filepath.Join(dir, "send.har"), "send", "locked", "Backlog", "WebPassword", "hunter2;", "Dimmer", "10")
+
+	for _, name := range []string{"status.har", "send.har"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var session harFile
+		if err := json.Unmarshal(b, &session); err != nil || len(session.Log.Entries) != 1 {
+			t.Errorf("%s = %s, want one entry", name, b)
+		}
+		for _, secret := range []string{"s3cret", "hunter2"} {
+			if strings.Contains(string(b), secret) {
+				t.Errorf("%s contains %s", name, secret)
+			}
+		}
+	}
+
+	// the replay doesn't see changes to the device
+	sim := e.sims["locked"]
+	sim.mu.Lock()
+	sim.name = "changed"
+	sim.mu.Unlock()
+
+	if out := e.ok("--replay", filepath.Join(dir, "status.har"), "status", "locked", "--all"); out != status {
+		t.Errorf("replayed status = %q, want %q", out, status)
+	}
+	res := e.run("--replay", filepath.Join(dir, "status.har"), "status", "lamp")
+	if res.code != exitUnreachable || !strings.Contains(res.stderr, "no response was recorded") {
+		t.Errorf("status lamp without a recording = %+v", res)
+	}
+}
+
+func TestRecordAndReplayTimeout(t *testing.T) {
+	e := newTestEnv(t)
+	session := filepath.Join(t.TempDir(), "session.har")
+
+	// a device that stops answering
+	hung := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hung }))
+	defer server.Close()
+	defer close(hung)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	if res := e.run("--record", session, "status", "--host", host, "--timeout", "50ms"); res.code != exitTimeout {
+		t.Fatalf("status of a hung device exit code = %d, want %d", res.code, exitTimeout)
+	}
+	if res := e.run("--replay", session, "status", "--host", host); res.code != exitTimeout {
+		t.Errorf("replayed timeout exit code = %d, want %d", res.code, exitTimeout)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		fault string
+		args  []string
+		code  int
+	}{
+		{"timeout", []string{"status", "lamp", "--timeout", "100ms"}, exitTimeout},
+		{"timeout,times=1", []string{"status", "lamp", "--timeout", "100ms", "--retries", "1"}, exitOK},
+		{"timeout,times=1", []string{"power", "on", "lamp", "--timeout", "100ms", "--retries", "1"}, exitTimeout},
+		{"timeout,times=1", []string{"power", "on", "lamp", "--timeout", "100ms", "--retries", "1", "--retry-writes"}, exitOK},
+		{"latency=300ms", []string{"status", "lamp", "--timeout", "100ms"}, exitTimeout},
+		{"latency=100ms", []string{"status", "lamp", "--timeout", "1s"}, exitOK},
+		{"reset", []string{"status", "lamp"}, exitUnreachable},
+		{"reset,times=2", []string{"status", "lamp", "--retries", "2"}, exitOK},
+		{"401", []string{"status", "lamp"}, exitAuthRequired},
+		{"500", []string{"status", "lamp", "--retries", "1"}, exitHTTP},
+		{"503,times=1", []string{"status", "lamp", "--retries", "1"}, exitOK},
+		{"truncated", []string{"status", "lamp"}, exitInvalidJSON},
+		{"malformed", []string{"timers", "list", "lamp"}, exitInvalidJSON},
+		{"500,command=timers", []string{"status", "lamp"}, exitOK},
+		{"reboot", []string{"power", "on", "lamp"}, exitUnreachable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fault+" "+strings.Join(tt.args, " "), func(t *testing.T) {
+			e := newTestEnv(t)
+			f, err := parseFault(tt.fault)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.sims["lamp"].addFault(f)
+
+			if res := e.run(append(tt.args, "--retry-backoff", "1ms")...); res.code != tt.code {
+				t.Errorf("exit code = %d, want %d\nstdout: %s\nstderr: %s", res.code, tt.code, res.stdout, res.stderr)
+			}
+		})
+	}
+}
+
+func TestRebootFault(t *testing.T) {
+	e := newTestEnv(t)
+	f, err := parseFault("reboot=300ms,command=power")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.sims["lamp"].addFault(f)
+
+	if res := e.run("power", "on", "lamp"); res.code != exitUnreachable {
+		t.Errorf("power on exit code = %d, want %d", res.code, exitUnreachable)
+	}
+	// nothing answers while it restarts, then the relay is as it was
+	if res := e.run("status", "lamp"); res.code != exitUnreachable {
+		t.Errorf("status while restarting exit code = %d, want %d", res.code, exitUnreachable)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if out := e.ok("status", "lamp"); out != "OFF\n" {
+		t.Errorf("status after restarting = %q, want OFF", out)
+	}
+	if out := e.ok("logs", "lamp"); !strings.Contains(out, "APP: Restarted") {
+		t.Errorf("logs after restarting = %q", out)
+	}
+}
+
+func TestSimulateFaults(t *testing.T) {
+	e := newTestEnv(t)
+
+	if res := e.run("simulate", "--fault", "slow"); res.code != exitError {
+		t.Errorf("simulate with an invalid fault exit code = %d, want %d", res.code, exitError)
+	}
+
+	_, line := e.start("Simulating", "simulate", "--listen", "127.0.0.1:0", "--fault", "500,command=timers")
+	host := line[strings.LastIndex(line, " ")+1:]
+
+	e.ok("status", host)
+	if res := e.run("timers", "list", host); res.code != exitHTTP {
+		t.Errorf("timers list exit code = %d, want %d", res.code, exitHTTP)
+	}
+}
+
+func TestHealthCommand(t *testing.T) {
+	e := newTestEnv(t)
+
+	out := e.ok("health", "all")
+	for _, want := range []string{"lamp   signal", "strip  time", "OK"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("health all = %q, missing %q", out, want)
+		}
+	}
+
+	// the simulator has 26kB of heap
+	if res := e.run("health", "lamp", "--threshold", "heap=30:20"); res.code != healthWarn || !strings.Contains(res.stdout, "WARN") {
+		t.Errorf("health with a heap warning = %+v", res)
+	}
+
+	e.writeConfig(fmt.Sprintf("health:\n  heap: 40:30\ndevices:\n  lamp: %s\n", e.hosts["lamp"]))
+	var results []HealthResult
+	res := e.run("health", "lamp", "--output", "json")
+	if err := json.Unmarshal([]byte(res.stdout), &results); err != nil {
+		t.Fatal(err)
+	}
+	if res.code != healthCrit || len(results) != len(healthChecks) || results[3].Check != "heap" || results[3].State != "CRIT" {
+		t.Errorf("health with a heap setting = %d, %+v", res.code, results)
+	}
+
+	if res := e.run("health", "--host", e.hosts["locked"]); res.code != healthCrit || !strings.Contains(res.stdout, "requires a username") {
+		t.Errorf("health of a device it can't read = %+v", res)
+	}
+}
+
+func TestNagiosOutput(t *testing.T) {
+	e := newTestEnv(t)
+	e.ok("power", "on", "lamp")
+
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{[]string{"status", "lamp"}, healthOK, "OK - lamp is ON\n"},
+		{[]string{"status", "all"}, healthOK, "OK - lamp is ON, strip is OFF\n"},
+		{[]string{"status", "lamp", "--all", "-c", "Status.Power=1:1"}, healthOK, "| Status.Power=1;;1:1\n"},
+		{[]string{"status", "strip", "--all", "-c", "Status.Power=1:"}, healthCrit, "CRITICAL - strip is up"},
+		{[]string{"send", "lamp", "Status 8", "-w", "StatusSNS.ENERGY.Power=~:40", "-c", "StatusSNS.ENERGY.Power=~:100"}, healthWarn, "WARNING - lamp replied to Status 8, StatusSNS.ENERGY.Power is 42 | StatusSNS.ENERGY.Power=42;~:40;~:100\n"},
+		{[]string{"status", "lamp", "--all", "-c", "StatusSNS.ENERGY.Power=2000"}, healthOK, "| StatusSNS.ENERGY.Power=42;;2000\n"},
+		{[]string{"status", "lamp", "--all", "-w", "StatusSNS.ENERGY.Power=~:40"}, healthWarn, "WARNING - lamp is up"},
+		{[]string{"send", "lamp", "Status 8", "-c", "StatusSNS.*.Temperature=30"}, healthUnknown, "UNKNOWN - lamp replied to Status 8, no fields match StatusSNS.*.Temperature\n"},
+		{[]string{"health", "lamp"}, healthOK, "OK - all 8 checks are ok | signal=-65;-75:;-85:"},
+		{[]string{"health", "lamp", "--threshold", "heap=40:30"}, healthCrit, "CRITICAL - lamp heap: free heap is 26kB |"},
+		{[]string{"status", "--host", e.hosts["locked"]}, healthUnknown, "UNKNOWN - " + e.hosts["locked"] + ": device requires"},
+		{[]string{"status", "nope"}, healthUnknown, "UNKNOWN - "},
+		{[]string{"timers", "list", "lamp"}, healthUnknown, "UNKNOWN - --nagios can only be used with: status, health, send\n"},
+		{[]string{"status", "lamp", "-c", "Power=x"}, healthUnknown, "UNKNOWN - range \"x\" is invalid"},
+	}
+
+	for _, tt := range tests {
+		res := e.run(append(tt.args, "--nagios")...)
+		if res.code != tt.code || !strings.Contains(res.stdout, tt.want) || strings.Count(res.stdout, "\n") != 1 {
+			t.Errorf("%s --nagios = %d %q, want %d %q", strings.Join(tt.args, " "), res.code, res.stdout, tt.code, tt.want)
+		}
+	}
+
+	closed := httptest.NewServer(nil)
+	closed.Close()
+	if res := e.run("status", "--host", strings.TrimPrefix(closed.URL, "http://"), "--nagios"); res.code != healthCrit || !strings.HasPrefix(res.stdout, "CRITICAL - ") {
+		t.Errorf("status of an unreachable device --nagios = %+v", res)
+	}
+
+	if res := e.run("status", "lamp", "-w", "Power=1"); res.code != exitError {
+		t.Errorf("-w without --nagios exit code = %d, want %d", res.code, exitError)
+	}
+}
+
+func TestWifi(t *testing.T) {
+	e := newTestEnv(t)
+
+	strip := e.sims["strip"]
+	strip.mu.Lock()
+	strip.wifi = simWifi{ssid: "upstairs", bssid: "AA:BB:CC:DD:EE:01", channel: 11, signal: -80, linkCount: 6}
+	strip.mu.Unlock()
+
+	out := e.ok("wifi")
+	for _, want := range []string{
+		"simulated AA:BB:CC:DD:EE:FF channel 6: 2 devices",
+		"upstairs AA:BB:CC:DD:EE:01 channel 11: 1 device",
+		"signal is -80dBm, quality is 40%, 5 reconnects",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("wifi = %q, missing %q", out, want)
+		}
+	}
+
+	var results []WifiResult
+	if err := json.Unmarshal([]byte(e.ok("wifi", "all", "--output", "json", "--reconnects", "10")), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Device != "strip" || strings.Join(results[0].Problems, ", ") != "signal is -80dBm, quality is 40%" || results[1].State != "OK" {
+		t.Errorf("wifi all = %+v", results)
+	}
+
+	e.writeConfig(fmt.Sprintf("devices:\n  lamp: %s\n  gone: 127.0.0.1:1\n", e.hosts["lamp"]))
+	if res := e.run("wifi"); res.code != 10 || !strings.Contains(res.stdout, "Unreachable: 1 device") || !strings.Contains(res.stdout, "lamp") {
+		t.Errorf("wifi with an unreachable device = %+v", res)
+	}
+
+	out = e.ok("wifi", "lamp", "--scan", "--output", "csv")
+	want := "SSID,BSSID,Channel,Signal,RSSI,Encryption\n" +
+		"guest,AA:BB:CC:DD:EE:00,11,-58,84,OPEN\n" +
+		"simulated,AA:BB:CC:DD:EE:FF,6,-65,70,WPA2/PSK\n" +
+		"neighbour,11:22:33:44:55:66,1,-82,36,WPA2/PSK\n"
+	if out != want {
+		t.Errorf("wifi --scan = %q, want %q", out, want)
+	}
+
+	if res := e.run("wifi", "--scan"); res.code == 0 || !strings.Contains(res.stderr, "--scan works on a single device") {
+		t.Errorf("wifi --scan of every device = %+v", res)
+	}
+}
+
+func TestNetworkSet(t *testing.T) {
+	e := newTestEnv(t)
+	_, port, _ := net.SplitHostPort(e.hosts["lamp"])
+
+	out := e.ok("network", "set", "lamp", "--ip", "127.0.0.1", "--gateway", "192.168.2.1", "--dns", "1.1.1.1")
+	if !strings.Contains(out, "lamp: set IPAddress1, IPAddress2, IPAddress4") {
+		t.Errorf("network set = %q", out)
+	}
+	if addresses := e.wifi("lamp").addresses; addresses != [4]string{"127.0.0.1", "192.168.2.1", "255.255.255.0", "1.1.1.1"} {
+		t.Errorf("addresses after network set = %v", addresses)
+	}
+	if config, _ := os.ReadFile(e.config); !strings.Contains(string(config), "lamp: 127.0.0.1:"+port+"\n") {
+		t.Errorf("configuration after network set = %s", config)
+	}
+
+	if res := e.run("network", "set", "all", "--ssid", "attic"); res.code != 1 || !strings.Contains(res.stderr, "use --force") {
+		t.Errorf("network set of a group without --force = %+v", res)
+	}
+	if res := e.run("network", "set", "all", "--ip", "10.0.0.2"); res.code != 1 || !strings.Contains(res.stderr, "single device") {
+		t.Errorf("network set of a group with --ip = %+v", res)
+	}
+	e.ok("network", "set", "all", "--ssid", "attic", "--force")
+	for _, name := range []string{"lamp", "strip"} {
+		if wifi := e.wifi(name); wifi.ssid != "attic" {
+			t.Errorf("ssid of %s = %s", name, wifi.ssid)
+		}
+	}
+
+	// the wifi password mustn't be taken for the password of devices
+	e.writeConfig(fmt.Sprintf("password: s3cret\ndevices:\n  locked: %s\n", e.hosts["locked"]))
+	cmd := e.command("network", "set", "locked", "--password", "${WIFI_PASSWORD}", "--verbose")
+	cmd.Env = append(cmd.Env, "WIFI_PASSWORD=correct horse")
+	if out, err := cmd.CombinedOutput(); err != nil || !strings.Contains(string(out), "URL: ") || strings.Contains(string(out), "horse") || strings.Contains(string(out), "s3cret") {
+		t.Errorf("network set --password --verbose = %v %s", err, out)
+	}
+	if wifi := e.wifi("locked"); wifi.password != "correct horse" {
+		t.Errorf("wifi password = %q", wifi.password)
+	}
+
+	for _, args := range [][]string{
+		{"network", "set", "locked"},
+		{"network", "set", "locked", "--password", "short"},
+		{"network", "set", "locked", "--netmask", "255.255.255"},
+	} {
+		if res := e.run(args...); res.code != 1 {
+			t.Errorf("%s = %+v, want an error", strings.Join(args, " "), res)
+		}
+	}
+}
+
+func TestMqtt(t *testing.T) {
+	e := newTestEnv(t)
+
+	var results []MqttResult
+	if err := json.Unmarshal([]byte(e.ok("mqtt", "show", "--output", "json")), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[0].Device != "lamp" || results[0].Topic != "lamp" || results[0].Host != "" {
+		t.Errorf("mqtt show = %+v", results)
+	}
+
+	if res := e.run("mqtt", "set", "all", "--topic", "same"); res.code != 1 || !strings.Contains(res.stderr, "can't share a topic") {
+		t.Errorf("mqtt set of a group with a topic = %+v", res)
+	}
+
+	// --host and --password are the broker's, not the device's
+	cmd := e.command("mqtt", "set", "locked", "--host", "mqtt.home", "--user", "tasmota", "--password", "${MQTT_PASSWORD}", "--verbose")
+	cmd.Env = append(cmd.Env, "MQTT_PASSWORD=hunter2")
+	if out, err := cmd.CombinedOutput(); err != nil || !strings.Contains(string(out), "locked: set MqttHost, MqttUser, MqttPassword") || strings.Contains(string(out), "hunter2") {
+		t.Errorf("mqtt set = %v %s", err, out)
+	}
+	e.ok("mqtt", "set", "all", "--host", "mqtt.home", "--grouptopic", "downstairs")
+	e.ok("mqtt", "set", "strip", "--topic", "lamp")
+
+	locked := e.sims["locked"]
+	locked.mu.Lock()
+	mqtt := locked.mqtt
+	locked.mu.Unlock()
+	if mqtt.host != "mqtt.home" || mqtt.user != "tasmota" || mqtt.password != "hunter2" {
+		t.Errorf("mqtt settings of locked = %+v", mqtt)
+	}
+
+	res := e.run("mqtt", "audit")
+	want := "lamp: topic lamp is also used by strip\nstrip: topic lamp is also used by lamp\n"
+	if res.code != 1 || res.stdout != want || res.stderr != "" {
+		t.Errorf("mqtt audit = %+v, want %q", res, want)
+	}
+
+	res = e.run("mqtt", "audit", "all", "--broker", "mqtt.home:1884")
+	if res.code != 1 || !strings.Contains(res.stdout, "lamp: uses broker mqtt.home:1883, not mqtt.home:1884") {
+		t.Errorf("mqtt audit with a broker = %+v", res)
+	}
+
+	e.ok("mqtt", "set", "strip", "--topic", "strip")
+	if out := e.ok("mqtt", "audit"); out != "No problems found\n" {
+		t.Errorf("mqtt audit = %q", out)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	e := newTestEnv(t)
+
+	if out := e.ok("audit"); out != "No problems found\n" {
+		t.Errorf("audit = %q", out)
+	}
+	if _, err := os.Stat(filepath.Join(e.home, ".cache", applicationName, "devices.json")); err != nil {
+		t.Errorf("audit didn't save the cache: %s", err)
+	}
+
+	// the lamp's address now belongs to another device, which shares the strip's topic
+	lamp := e.sims["lamp"]
+	lamp.mu.Lock()
+	lamp.mac = "DC:4F:22:FF:FF:FF"
+	lamp.mqtt.topic = "strip"
+	lamp.mu.Unlock()
+
+	for i := 0; i < 2; i++ {
+		res := e.run("audit")
+		for _, want := range []string{
+			"lamp: mac address changed from DC:4F:22:",
+			" to DC:4F:22:FF:FF:FF since ",
+			"lamp: topic strip is also used by strip\nstrip: topic strip is also used by lamp\n",
+		} {
+			if res.code != 1 || !strings.Contains(res.stdout, want) {
+				t.Errorf("audit run %d = %+v, missing %q", i+1, res, want)
+			}
+		}
+	}
+
+	e.run("audit", "lamp", "--accept")
+	if out := e.run("audit", "all").stdout; strings.Contains(out, "mac address changed") {
+		t.Errorf("audit after --accept = %q", out)
+	}
+
+	// a configured mac address is compared ignoring its case and separators, and --accept changes it
+	e.writeConfig(fmt.Sprintf("devices:\n  lamp:\n    host: %s\n    mac: dc-4f-22-ff-ff-ff\n", e.hosts["lamp"]))
+	if out := e.ok("audit"); out != "No problems found\n" {
+		t.Errorf("audit with the configured mac in another form = %q", out)
+	}
+	lamp.mu.Lock()
+	lamp.mac = "DC:4F:22:EE:EE:EE"
+	lamp.mu.Unlock()
+	if res := e.run("audit"); res.code != 1 || !strings.Contains(res.stdout, "lamp: mac address is DC:4F:22:EE:EE:EE, not dc-4f-22-ff-ff-ff as configured") {
+		t.Errorf("audit with a different configured mac = %+v", res)
+	}
+	e.ok("audit", "--accept")
+	if b, _ := os.ReadFile(e.config); !strings.Contains(string(b), "mac: DC:4F:22:EE:EE:EE") {
+		t.Errorf("config after audit --accept:\n%s", b)
+	}
+	if out := e.ok("audit"); out != "No problems found\n" {
+		t.Errorf("audit after accepting the configured mac = %q", out)
+	}
+
+	e.writeConfig(fmt.Sprintf("devices:\n  strip: %s\n  gone: 127.0.0.1:1\n  again: %s\n", e.hosts["strip"], e.hosts["strip"]))
+	res := e.run("audit", "--output", "json")
+	var problems []AuditResult
+	if err := json.Unmarshal([]byte(res.stdout), &problems); err != nil {
+		t.Fatal(err)
+	}
+	if res.code != 1 || len(problems) != 11 || problems[0].Device != "again" || !strings.HasPrefix(problems[5].Problem, "can't be read: 127.0.0.1:1: could not connect") {
+		t.Errorf("audit of a device given twice = %+v", problems)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	e := newTestEnv(t)
+	lampMac, stripMac := e.sims["lamp"].mac, e.sims["strip"].mac
+
+	out := e.ok("resolve")
+	if strings.Count(out, "mac address recorded") != 3 {
+		t.Errorf("first resolve = %q", out)
+	}
+	b, err := os.ReadFile(e.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := string(b)
+	want := fmt.Sprintf("  lamp:\n    host: %s\n    mac: %s\n", e.hosts["lamp"], lampMac)
+	if !strings.Contains(config, want) {
+		t.Errorf("config after resolve = %q, missing %q", config, want)
+	}
+	if out := e.ok("resolve", "all"); strings.Count(out, " ok\n") != 2 {
+		t.Errorf("resolve of devices in place = %q", out)
+	}
+
+	// the lamp has gone from its address, but is still where it was last seen
+	e.writeConfig(strings.Replace(config, "host: "+e.hosts["lamp"], "host: 127.0.0.1:1", 1))
+	if out := e.ok("resolve", "lamp"); !strings.Contains(out, e.hosts["lamp"]+" "+lampMac+" moved from 127.0.0.1:1") {
+		t.Errorf("resolve from the cache = %q", out)
+	}
+	if b, _ := os.ReadFile(e.config); !strings.Contains(string(b), want) {
+		t.Errorf("config after the lamp moved = %q, missing %q", b, want)
+	}
+
+	// the strip now has the lamp's address, and the lamp has to be searched for
+	os.RemoveAll(filepath.Join(e.home, ".cache"))
+	e.writeConfig(fmt.Sprintf("devices:\n  lamp:\n    host: %s\n    mac: %s\n", e.hosts["strip"], strings.ToLower(lampMac)))
+	res := e.run("resolve")
+	if res.code != 1 || !strings.Contains(res.stdout, e.hosts["strip"]+" is now "+stripMac+", set subnets to search for it") {
+		t.Errorf("resolve without subnets = %+v", res)
+	}
+	if res := e.run("audit"); res.code != 1 || !strings.Contains(res.stdout, "lamp: mac address is "+stripMac+", not ") {
+		t.Errorf("audit of a device with the wrong mac = %+v", res)
+	}
+
+	res = e.run("resolve", "--subnets", "127.0.0.1:1,"+e.hosts["lamp"], "--scan-timeout", "500ms", "--output", "json")
+	var results []ResolveResult
+	if err := json.Unmarshal([]byte(res.stdout), &results); err != nil {
+		t.Fatal(err)
+	}
+	if res.code != 0 || len(results) != 1 || results[0].Address != e.hosts["lamp"] || results[0].Result != "moved from "+e.hosts["strip"] {
+		t.Errorf("resolve by searching = %+v", res)
+	}
+
+	// a plain number of seconds, as for the other durations
+	os.RemoveAll(filepath.Join(e.home, ".cache"))
+	e.writeConfig(fmt.Sprintf("subnets: [%s]\nscan-timeout: 2\ndevices:\n  lamp:\n    host: 127.0.0.1:1\n    mac: %s\n", e.hosts["lamp"], lampMac))
+	if out := e.ok("resolve"); !strings.Contains(out, "moved from 127.0.0.1:1") {
+		t.Errorf("resolve with a scan-timeout in seconds = %q", out)
+	}
+
+	e.writeConfig("subnets: [10.0.0.0/8]\ndevices:\n  lamp: 127.0.0.1:1\n")
+	if res := e.run("resolve"); res.code == 0 || !strings.Contains(res.stderr, "subnets 10.0.0.0/8 is too large to search, it can be up to a /20") {
+		t.Errorf("resolve with a large subnet = %+v", res)
+	}
+	e.writeConfig("devices:\n  lamp: 127.0.0.1:1\n")
+	if res := e.run("resolve", "--host", e.hosts["lamp"]); res.code == 0 || !strings.Contains(res.stderr, "isn't a configured device") {
+		t.Errorf("resolve of an address = %+v", res)
+	}
+}
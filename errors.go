@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// kinds of error, each with its own exit code
+var (
+	ErrUnreachable    = errors.New("could not connect to device")
+	ErrTimeout        = errors.New("timed out waiting for device")
+	ErrAuthRequired   = errors.New("device requires a username and password")
+	ErrHTTP           = errors.New("device returned an http error")
+	ErrInvalidJSON    = errors.New("device returned invalid json")
+	ErrUnknownCommand = errors.New("device does not know the command")
+	ErrNotVerified    = errors.New("device did not reach the requested state")
+)
+
+// exit codes
+const (
+	exitOK             = 0
+	exitError          = 1
+	exitUnreachable    = 10
+	exitTimeout        = 11
+	exitAuthRequired   = 12
+	exitHTTP           = 13
+	exitInvalidJSON    = 14
+	exitUnknownCommand = 15
+	exitNotVerified    = 16
+)
+
+// name and exit code of each kind of error, used for machine readable errors
+var errorKinds = []struct {
+	err  error
+	name string
+	code int
+}{
+	{ErrUnreachable, "unreachable", exitUnreachable},
+	{ErrTimeout, "timeout", exitTimeout},
+	{ErrAuthRequired, "auth_required", exitAuthRequired},
+	{ErrHTTP, "http_error", exitHTTP},
+	{ErrInvalidJSON, "invalid_json", exitInvalidJSON},
+	{ErrUnknownCommand, "unknown_command", exitUnknownCommand},
+	{ErrNotVerified, "verify_failed", exitNotVerified},
+}
+
+// an error talking to a device
+type DeviceError struct {
+	Kind    error  // one of the Err kinds
+	Address string // address of the device
+	Status  int    // http status code, if there was a response
+	Err     error  // underlying error, if any
+}
+
+func (e *DeviceError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Address, e.Kind)
+	if e.Status != 0 {
+		msg += fmt.Sprintf(" (http status %d)", e.Status)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *DeviceError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *DeviceError) Unwrap() error {
+	return e.Err
+}
+
+// failure of some devices in a group, each failure has already been printed
+type GroupError struct {
+	Failures int
+	Total    int
+	Err      error // first failure, which decides the exit code
+}
+
+func (e *GroupError) Error() string {
+	return fmt.Sprintf("%d of %d devices failed", e.Failures, e.Total)
+}
+
+func (e *GroupError) Unwrap() error {
+	return e.Err
+}
+
+// structure of errors when outputting json
+type ErrorResponse struct {
+	Error struct {
+		Kind     string `json:"Kind"`
+		Message  string `json:"Message"`
+		Device   string `json:"Device,omitempty"`
+		Status   int    `json:"Status,omitempty"`
+		ExitCode int    `json:"ExitCode"`
+	} `json:"Error"`
+}
+
+// convert an error from the http client into a device error
+func connectionError(address string, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &DeviceError{Kind: ErrTimeout, Address: address, Err: err}
+	}
+	return &DeviceError{Kind: ErrUnreachable, Address: address, Err: err}
+}
+
+// name and exit code of an error
+func errorKind(err error) (string, int) {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.name, k.code
+		}
+	}
+	return "error", exitError
+}
+
+// print an error, as json if json output was chosen, and exit with the matching exit code
+func exitWithError(err error) {
+	restoreTerminal()
+
+	// checks have been printed, only the exit code is left to give
+	var checkErr *CheckError
+	if errors.As(err, &checkErr) {
+		os.Exit(checkErr.State)
+	}
+	if nagiosOutput() {
+		exitPluginError(err)
+	}
+
+	kind, code := errorKind(err)
+
+	if outputFormat() != "json" {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(code)
+	}
+
+	res := ErrorResponse{}
+	res.Error.Kind = kind
+	res.Error.Message = err.Error()
+	res.Error.ExitCode = code
+
+	var deviceErr *DeviceError
+	if errors.As(err, &deviceErr) {
+		res.Error.Device = deviceErr.Address
+		res.Error.Status = deviceErr.Status
+	}
+
+	s, _ := json.MarshalIndent(res, "", "\t")
+	fmt.Println(string(s))
+	os.Exit(code)
+}
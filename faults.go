@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long a simulated device takes to restart, unless a reboot fault says otherwise
+const simBootTime = time.Second
+
+// a fault a simulated device has, happening to requests chosen by count so tests are repeatable
+type simFault struct {
+	kind    string        // latency, timeout, reset, truncated, malformed, reboot or an http status
+	delay   time.Duration // added by latency, and how long a reboot takes
+	command string        // only requests for this command, e.g. power, when set
+	after   int           // number of requests let through first
+	times   int           // number of requests the fault happens to, 0 is every one
+	every   int           // only every nth request, when set
+
+	seen    int // requests matching the command so far
+	applied int // requests the fault has happened to
+}
+
+// parse a fault given to simulate --fault, e.g.
+//
+//	latency=300ms             every request takes 300ms longer
+//	timeout,times=2           the first two requests never get a response
+//	reset,every=3             every third connection is reset
+//	500,after=5               requests after the first five get http 500
+//	401,command=power         power commands are refused as unauthorized
+//	truncated                 responses are cut off half way
+//	malformed                 responses are invalid json
+//	reboot=2s,command=power   power commands restart the device, which takes 2s
+func parseFault(spec string) (*simFault, error) {
+	parts := strings.Split(spec, ",")
+	kind, value, hasValue := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	f := &simFault{kind: strings.ToLower(kind)}
+
+	switch f.kind {
+	case "latency", "reboot":
+		if f.kind == "reboot" && !hasValue {
+			f.delay = simBootTime
+			break
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("fault %s needs a duration, e.g. %s=500ms", f.kind, f.kind)
+		}
+		f.delay = d
+	case "timeout", "reset", "truncated", "malformed":
+		if hasValue {
+			return nil, fmt.Errorf("fault %s doesn't take a value", f.kind)
+		}
+	default:
+		if status, err := strconv.Atoi(f.kind); err != nil || status < 400 || status > 599 || hasValue {
+			return nil, fmt.Errorf("fault \"%s\" is invalid, must be one of: latency, timeout, reset, truncated, malformed, reboot or an http status such as 500", kind)
+		}
+	}
+
+	for _, option := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		if key == "command" {
+			f.command = strings.ToLower(value)
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("fault option %s needs a whole number", key)
+		}
+		switch key {
+		case "after":
+			f.after = n
+		case "times":
+			f.times = n
+		case "every":
+			f.every = n
+		default:
+			return nil, fmt.Errorf("fault option \"%s\" is invalid, must be one of: after, times, every, command", key)
+		}
+	}
+	return f, nil
+}
+
+// add a fault to a simulated device
+func (s *simulator) addFault(f *simFault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, f)
+}
+
+// check if a fault happens to a request, counting it, must be called with the lock held
+func (f *simFault) matches(r *http.Request) bool {
+	if f.command != "" {
+		name, _, _ := splitCommand(strings.TrimSpace(r.URL.Query().Get("cmnd")))
+		if r.URL.Path != "/cm" || name != f.command {
+			return false
+		}
+	}
+
+	f.seen++
+	n := f.seen - f.after
+	if n <= 0 || (f.every > 0 && n%f.every != 0) || (f.times > 0 && f.applied >= f.times) {
+		return false
+	}
+	f.applied++
+	return true
+}
+
+// serve a request through the faults it has, returning false if a fault took care of it
+func (s *simulator) serveFaults(w http.ResponseWriter, r *http.Request) bool {
+	s.mu.Lock()
+	if time.Now().Before(s.bootUntil) {
+		// still restarting, so nothing answers
+		s.mu.Unlock()
+		dropConnection(w, false)
+		return false
+	}
+	var faults []*simFault
+	for _, f := range s.faults {
+		if f.matches(r) {
+			faults = append(faults, f)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, f := range faults {
+		switch f.kind {
+		case "latency":
+			time.Sleep(f.delay)
+
+		case "timeout":
+			// wait for the client to give up
+			<-r.Context().Done()
+			return false
+
+		case "reset":
+			dropConnection(w, true)
+			return false
+
+		case "reboot":
+			// the command is lost, and relays come back as they were saved
+			s.mu.Lock()
+			s.started = time.Now()
+			s.bootUntil = s.started.Add(f.delay)
+			s.addLog("APP: Restarted")
+			s.mu.Unlock()
+			dropConnection(w, false)
+			return false
+
+		case "truncated", "malformed":
+			// run the request as usual, then spoil the response
+			buf := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+			s.serve(buf, r)
+			body := buf.body.Bytes()
+			if f.kind == "truncated" {
+				body = body[:len(body)/2]
+			} else {
+				body = malformed(body)
+			}
+			for k, v := range buf.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(buf.status)
+			w.Write(body)
+			return false
+
+		default:
+			status, _ := strconv.Atoi(f.kind)
+			http.Error(w, http.StatusText(status), status)
+			return false
+		}
+	}
+	return true
+}
+
+// a response kept back so it can be spoilt before it is sent
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// break json the way tasmota has been known to, with a trailing comma
+func malformed(body []byte) []byte {
+	i := bytes.LastIndexByte(body, '}')
+	if i < 0 {
+		return append(body, '{')
+	}
+	return append(append(body[:i:i], ','), body[i:]...)
+}
+
+// close the connection without a response, resetting it if asked to rather than closing it
+func dropConnection(w http.ResponseWriter, reset bool) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection can't be dropped", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok && reset {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
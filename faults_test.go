@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseFault(t *testing.T) {
+	tests := []struct {
+		spec string
+		want simFault
+	}{
+		{"timeout", simFault{kind: "timeout"}},
+		{"latency=300ms", simFault{kind: "latency", delay: 300 * time.Millisecond}},
+		{"reboot", simFault{kind: "reboot", delay: simBootTime}},
+		{"Reset,every=3", simFault{kind: "reset", every: 3}},
+		{"500,after=5,times=2,command=Power", simFault{kind: "500", after: 5, times: 2, command: "power"}},
+	}
+	for _, tt := range tests {
+		got, err := parseFault(tt.spec)
+		if err != nil {
+			t.Errorf("parseFault(%q): %s", tt.spec, err)
+		} else if *got != tt.want {
+			t.Errorf("parseFault(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "slow", "latency", "latency=fast", "timeout=1s", "200", "600", "reset,times=x", "reset,often=2"} {
+		if _, err := parseFault(spec); err == nil {
+			t.Errorf("parseFault(%q) didn't fail", spec)
+		}
+	}
+}
+
+func TestFaultMatches(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string // which of eight requests the fault happens to
+	}{
+		{"500", "xxxxxxxx"},
+		{"500,times=2", "xx......"},
+		{"500,after=2", "..xxxxxx"},
+		{"500,every=3", "..x..x.."},
+		{"500,after=1,every=2,times=2", "..x.x..."},
+	}
+	for _, tt := range tests {
+		f, err := parseFault(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for i := 0; i < len(tt.want); i++ {
+			if f.matches(httptest.NewRequest("GET", "/cm?cmnd=Power", nil)) {
+				got += "x"
+			} else {
+				got += "."
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s happened to %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+
+	f, _ := parseFault("500,command=power")
+	if f.matches(httptest.NewRequest("GET", "/cm?cmnd=Status0", nil)) || f.matches(httptest.NewRequest("GET", "/cs?c2=0", nil)) {
+		t.Error("a power fault happened to another request")
+	}
+	if !f.matches(httptest.NewRequest("GET", "/cm?cmnd=Power2%20On", nil)) {
+		t.Error("a power fault didn't happen to Power2 On")
+	}
+}
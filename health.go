@@ -0,0 +1,400 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// states of a health check, which are also the exit codes nagios plugins use
+const (
+	healthOK = iota
+	healthWarn
+	healthCrit
+	healthUnknown
+)
+
+var healthStates = []string{"OK", "WARN", "CRIT", "UNKNOWN"}
+
+// a check of a device's health, comparing a value from its status against warn and crit thresholds
+type healthCheck struct {
+	name        string
+	description string
+	low         bool // low values are bad rather than high ones
+	duration    bool // thresholds are durations, the value is in seconds
+	warn, crit  float64
+	evaluate    func(res StatusResponse, now time.Time) (value float64, message string, state int)
+}
+
+// checks run by health, evaluate returns -1 as the state to have the thresholds decide it
+var healthChecks = []healthCheck{
+	{
+		name: "signal", description: "wifi signal in dBm", low: true, warn: -75, crit: -85,
+		evaluate: func(res StatusResponse, now time.Time) (float64, string, int) {
+			return float64(res.StatusSTS.Wifi.Signal), fmt.Sprintf("wifi signal is %ddBm", res.StatusSTS.Wifi.Signal), -1
+		},
+	},
+	{
+		name: "rssi", description: "wifi quality in percent", low: true, warn: 40, crit: 20,
+		evaluate: func(res StatusResponse, now time.Time) (float64, string, int) {
+			return float64(res.StatusSTS.Wifi.Rssi), fmt.Sprintf("wifi quality is %d%%", res.StatusSTS.Wifi.Rssi), -1
+		},
+	},
+	{
+		name: "loadavg", description: "load average", warn: 50, crit: 80,
+		evaluate: func(res StatusResponse, now time.Time) (float64, string, int) {
+			return float64(res.StatusSTS.LoadAvg), fmt.Sprintf("load average is %d", res.StatusSTS.LoadAvg), -1
+		},
+	},
+	{
+		name: "heap", description: "free heap in kB", low: true, warn: 15, crit: 10,
+		evaluate: func(res StatusResponse, now time.Time) (float64, string, int) {
+			return float64(res.StatusSTS.Heap), fmt.Sprintf("free heap is %dkB", res.StatusSTS.Heap), -1
+		},
+	},
+	{
+		name: "restart", description: "uptime since a restart by a crash", low: true, duration: true, warn: 24 * 3600, crit: 3600,
+		evaluate: func(res StatusResponse, now time.Time) (float64, string, int) {
+			reason := res.StatusPRM.RestartReason
+			uptime := float64(res.StatusSTS.UptimeSec)
+			if !isCrashRestart(reason) {
+				return uptime, fmt.Sprintf("last restart was %s", reason), healthOK
+			}
+			return uptime, fmt.Sprintf("restarted by %s %s ago", reason, time.Duration(uptime)*time.Second), -1
+		},
+	},
+	{
+		name: "bootcount", description: "restarts per day", warn: 2, crit: 10,
+		evaluate: func(res StatusResponse, now time.Time) (float64, string, int) {
+			reset, err := parseDeviceTime(res.StatusPRM.BCResetTime)
+			if err != nil {
+				return 0, "boot count reset time is unknown", healthUnknown
+			}
+			// count at least a day so a fresh reset doesn't look like a boot loop
+			days := math.Max(now.Sub(reset).Hours()/24, 1)
+			rate := math.Round(float64(res.StatusPRM.BootCount)/days*100) / 100
+			return rate, fmt.Sprintf("%d restarts since %s, %g a day", res.StatusPRM.BootCount, reset.Format("2006-01-02"), rate), -1
+		},
+	},
+	{
+		name: "mqtt", description: "mqtt reconnects since restarting", warn: 3, crit: 10,
+		evaluate: func(res StatusResponse, now time.Time) (float64, string, int) {
+			switch {
+			case res.StatusMQT.MqttHost == "":
+				return 0, "mqtt is not used", healthOK
+			case res.StatusSTS.MqttCount == 0:
+				return 0, fmt.Sprintf("not connected to mqtt host %s", res.StatusMQT.MqttHost), healthCrit
+			}
+			reconnects := res.StatusSTS.MqttCount - 1
+			return float64(reconnects), fmt.Sprintf("%d mqtt reconnects since restarting", reconnects), -1
+		},
+	},
+	{
+		name: "time", description: "clock difference", duration: true, warn: 60, crit: 300,
+		evaluate: func(res StatusResponse, now time.Time) (float64, string, int) {
+			utc, err := parseDeviceTime(res.StatusTIM.Utc)
+			if err != nil {
+				return 0, fmt.Sprintf("time \"%s\" is unknown", res.StatusTIM.Utc), healthUnknown
+			}
+			// devices start in 1970 until they reach a time server
+			if utc.Year() < 2016 {
+				return 0, "time is not synced", healthCrit
+			}
+			skew := math.Abs(now.Sub(utc).Seconds())
+			return math.Round(skew), fmt.Sprintf("clock is %s out", time.Duration(skew)*time.Second), -1
+		},
+	},
+}
+
+var healthCmd = &cobra.Command{
+	Use:   "health [device|group]",
+	Short: "Check the health of a device or group",
+	Long: `Check the health of a device or group from its status: wifi signal and quality, load,
+free heap, restarts by crashes, how often it restarts, mqtt reconnects and whether its clock is
+right. Each check is OK, WARN or CRIT against thresholds given as warn:crit, which can be set
+in the configuration or with --threshold:
+
+  health:
+    signal: -70:-80
+    restart: 12h:30m
+
+The exit code is that of the worst check, as nagios plugins use:
+  0 ok, 1 warn, 2 crit, 3 unknown`,
+	Example: `  tasmota-cli health lamp
+  tasmota-cli health downstairs --threshold heap=20:12`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		devices, _, err := resolveTargets(args)
+		if err != nil {
+			return err
+		}
+		return runHealth(devices)
+	},
+}
+
+func init() {
+	var names []string
+	for _, c := range healthChecks {
+		names = append(names, c.name)
+	}
+	healthCmd.Flags().StringArray("threshold", nil, "Thresholds of a check as name=warn:crit, checks are: "+strings.Join(names, ", "))
+
+	rootCmd.AddCommand(healthCmd)
+}
+
+// result of a health check
+type HealthResult struct {
+	Device  string  `json:"Device"`
+	Check   string  `json:"Check"`
+	State   string  `json:"State"`
+	Value   float64 `json:"Value"`
+	Warn    float64 `json:"Warn"`
+	Crit    float64 `json:"Crit"`
+	Message string  `json:"Message"`
+
+	state int
+	low   bool
+}
+
+// a threshold of the check as a nagios range, low values alert below it and high values above it
+func (r HealthResult) warnRange(threshold float64) string {
+	value := strconv.FormatFloat(threshold, 'f', -1, 64)
+	if r.low {
+		return value + ":"
+	}
+	return "~:" + value
+}
+
+// checks that aren't all ok, as health, audits and --nagios report them, the results have been printed
+// so only the exit code is left
+type CheckError struct {
+	State int
+}
+
+func (e *CheckError) Error() string {
+	return fmt.Sprintf("checks are %s", healthStates[e.State])
+}
+
+// check the health of devices and display the results
+func runHealth(devices []Device) error {
+	checks, err := healthThresholds()
+	if err != nil {
+		return err
+	}
+
+	var results []HealthResult
+	worst := healthOK
+	for _, dev := range devices {
+		for _, r := range checkHealth(dev, checks, time.Now()) {
+			results = append(results, r)
+			worst = worseState(worst, r.state)
+		}
+	}
+
+	if err := render(os.Stdout, healthOutput(results)); err != nil {
+		return err
+	}
+	if worst != healthOK {
+		return &CheckError{State: worst}
+	}
+	return nil
+}
+
+// run the health checks on a device, an unreachable device is a single crit result
+func checkHealth(dev Device, checks []healthCheck, now time.Time) []HealthResult {
+	res := StatusResponse{}
+	response, err := sendTasmota(dev, commandList["statusall"])
+	if err == nil {
+		err = decodeResponse(dev.Host, response, &res)
+	}
+	if err != nil {
+		return []HealthResult{{Device: dev.Name, Check: "status", State: healthStates[healthCrit], Message: err.Error(), state: healthCrit}}
+	}
+
+	var results []HealthResult
+	for _, c := range checks {
+		value, message, state := c.evaluate(res, now)
+		if state < 0 {
+			state = c.state(value)
+		}
+		results = append(results, HealthResult{
+			Device:  dev.Name,
+			Check:   c.name,
+			State:   healthStates[state],
+			Value:   value,
+			Warn:    c.warn,
+			Crit:    c.crit,
+			Message: message,
+			state:   state,
+			low:     c.low,
+		})
+	}
+	return results
+}
+
+// state of a value against the thresholds of a check
+func (c healthCheck) state(value float64) int {
+	if c.low {
+		value, c.warn, c.crit = -value, -c.warn, -c.crit
+	}
+	switch {
+	case value >= c.crit:
+		return healthCrit
+	case value >= c.warn:
+		return healthWarn
+	}
+	return healthOK
+}
+
+// the worse of two states, unknown is worse than warn but not crit as nagios has it
+func worseState(a, b int) int {
+	rank := func(s int) int {
+		if s == healthUnknown {
+			return 2
+		}
+		if s == healthCrit {
+			return 3
+		}
+		return s
+	}
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+// the health checks with thresholds from the configuration and --threshold
+func healthThresholds() ([]healthCheck, error) {
+	checks := make([]healthCheck, len(healthChecks))
+	copy(checks, healthChecks)
+
+	set := func(spec, name, value string) error {
+		for i := range checks {
+			if checks[i].name != strings.ToLower(name) {
+				continue
+			}
+			warn, crit, err := checks[i].parseThresholds(value)
+			if err != nil {
+				return fmt.Errorf("%s is invalid: %s", spec, err)
+			}
+			checks[i].warn, checks[i].crit = warn, crit
+			return nil
+		}
+		return fmt.Errorf("%s is invalid: there is no health check %s", spec, name)
+	}
+
+	configured := viper.GetStringMapString("health")
+	for _, name := range sortedKeys(viper.GetStringMap("health")) {
+		if err := set("health setting "+name, name, configured[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, spec := range viper.GetStringSlice("threshold") {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("threshold \"%s\" is invalid, must be name=warn:crit", spec)
+		}
+		if err := set("threshold "+name, name, value); err != nil {
+			return nil, err
+		}
+	}
+	return checks, nil
+}
+
+// parse thresholds given as warn:crit, durations such as 12h:30m for checks of time
+func (c healthCheck) parseThresholds(value string) (float64, float64, error) {
+	warn, crit, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, 0, errors.New("must be warn:crit")
+	}
+
+	parse := func(s string) (float64, error) {
+		s = strings.TrimSpace(s)
+		if c.duration {
+			d, err := toDuration(s)
+			if err != nil {
+				return 0, fmt.Errorf("\"%s\" is not a duration such as 30m or 12h", s)
+			}
+			return d.Seconds(), nil
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("\"%s\" is not a number", s)
+		}
+		return n, nil
+	}
+
+	w, err := parse(warn)
+	if err != nil {
+		return 0, 0, err
+	}
+	cr, err := parse(crit)
+	if err != nil {
+		return 0, 0, err
+	}
+	if (c.low && cr > w) || (!c.low && cr < w) {
+		if c.low {
+			return 0, 0, errors.New("crit must be lower than warn, low values are bad for " + c.description)
+		}
+		return 0, 0, errors.New("crit must be higher than warn")
+	}
+	return w, cr, nil
+}
+
+// check the health setting of the configuration, a map of check names to warn:crit
+func isHealthThresholds(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return errors.New("must be a map of checks to warn:crit thresholds")
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := node.Content[i], node.Content[i+1]
+		check, ok := findHealthCheck(name.Value)
+		if !ok {
+			return fmt.Errorf("has unknown check %s", name.Value)
+		}
+		if _, _, err := check.parseThresholds(value.Value); err != nil || value.Kind != yaml.ScalarNode {
+			return fmt.Errorf("%s must be warn:crit thresholds", name.Value)
+		}
+	}
+	return nil
+}
+
+// find a health check by name
+func findHealthCheck(name string) (healthCheck, bool) {
+	for _, c := range healthChecks {
+		if c.name == strings.ToLower(name) {
+			return c, true
+		}
+	}
+	return healthCheck{}, false
+}
+
+// restart reasons that mean the device crashed
+func isCrashRestart(reason string) bool {
+	reason = strings.ToLower(reason)
+	for _, crash := range []string{"exception", "watchdog", "wdt", "brownout", "panic"} {
+		if strings.Contains(reason, crash) {
+			return true
+		}
+	}
+	return false
+}
+
+// parse a time from a device, newer firmware uses 2006-01-02T15:04:05 and older firmware ctime
+func parseDeviceTime(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02T15:04:05", time.RFC3339, time.ANSIC} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("time \"%s\" is invalid", s)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// a healthy status, as the simulator gives
+func healthyStatus(now time.Time) StatusResponse {
+	var res StatusResponse
+	res.StatusSTS.Wifi.Signal = -60
+	res.StatusSTS.Wifi.Rssi = 80
+	res.StatusSTS.LoadAvg = 19
+	res.StatusSTS.Heap = 25
+	res.StatusSTS.UptimeSec = 7 * 24 * 3600
+	res.StatusPRM.RestartReason = "Power On"
+	res.StatusPRM.BootCount = 10
+	res.StatusPRM.BCResetTime = now.AddDate(0, -1, 0).Format("2006-01-02T15:04:05")
+	res.StatusTIM.Utc = now.UTC().Format("2006-01-02T15:04:05")
+	return res
+}
+
+func TestHealthChecks(t *testing.T) {
+	now := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		check  string
+		change func(res *StatusResponse)
+		state  int
+	}{
+		{"signal", func(res *StatusResponse) {}, healthOK},
+		{"signal", func(res *StatusResponse) { res.StatusSTS.Wifi.Signal = -80 }, healthWarn},
+		{"signal", func(res *StatusResponse) { res.StatusSTS.Wifi.Signal = -90 }, healthCrit},
+		{"rssi", func(res *StatusResponse) { res.StatusSTS.Wifi.Rssi = 30 }, healthWarn},
+		{"loadavg", func(res *StatusResponse) { res.StatusSTS.LoadAvg = 99 }, healthCrit},
+		{"heap", func(res *StatusResponse) { res.StatusSTS.Heap = 12 }, healthWarn},
+		{"restart", func(res *StatusResponse) { res.StatusSTS.UptimeSec = 60 }, healthOK},
+		{"restart", func(res *StatusResponse) { res.StatusPRM.RestartReason = "Exception" }, healthOK},
+		{"restart", func(res *StatusResponse) {
+			res.StatusPRM.RestartReason = "Exception"
+			res.StatusSTS.UptimeSec = 7200
+		}, healthWarn},
+		{"restart", func(res *StatusResponse) {
+			res.StatusPRM.RestartReason = "Hardware Watchdog"
+			res.StatusSTS.UptimeSec = 60
+		}, healthCrit},
+		{"bootcount", func(res *StatusResponse) { res.StatusPRM.BootCount = 100 }, healthWarn},
+		{"bootcount", func(res *StatusResponse) { res.StatusPRM.BCResetTime = "" }, healthUnknown},
+		{"mqtt", func(res *StatusResponse) {
+			res.StatusMQT.MqttHost = "broker"
+			res.StatusSTS.MqttCount = 1
+		}, healthOK},
+		{"mqtt", func(res *StatusResponse) {
+			res.StatusMQT.MqttHost = "broker"
+			res.StatusSTS.MqttCount = 5
+		}, healthWarn},
+		{"mqtt", func(res *StatusResponse) { res.StatusMQT.MqttHost = "broker" }, healthCrit},
+		{"time", func(res *StatusResponse) { res.StatusTIM.Utc = now.Add(-2 * time.Minute).Format("2006-01-02T15:04:05") }, healthWarn},
+		{"time", func(res *StatusResponse) { res.StatusTIM.Utc = "1970-01-01T00:01:02" }, healthCrit},
+		{"time", func(res *StatusResponse) { res.StatusTIM.Utc = now.Format(time.ANSIC) }, healthOK},
+	}
+
+	for _, tt := range tests {
+		res := healthyStatus(now)
+		tt.change(&res)
+
+		check, ok := findHealthCheck(tt.check)
+		if !ok {
+			t.Fatalf("no health check %s", tt.check)
+		}
+		value, message, state := check.evaluate(res, now)
+		if state < 0 {
+			state = check.state(value)
+		}
+		if state != tt.state {
+			t.Errorf("%s = %s (%s), want %s", tt.check, healthStates[state], message, healthStates[tt.state])
+		}
+	}
+}
+
+func TestHealthThresholds(t *testing.T) {
+	signal, _ := findHealthCheck("signal")
+	restart, _ := findHealthCheck("restart")
+
+	tests := []struct {
+		check      healthCheck
+		value      string
+		warn, crit float64
+		ok         bool
+	}{
+		{signal, "-70:-80", -70, -80, true},
+		{signal, "-80:-70", 0, 0, false},
+		{signal, "-70", 0, 0, false},
+		{signal, "x:-80", 0, 0, false},
+		{restart, "12h:30m", 12 * 3600, 1800, true},
+		{restart, "30:60", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		warn, crit, err := tt.check.parseThresholds(tt.value)
+		if (err == nil) != tt.ok || warn != tt.warn || crit != tt.crit {
+			t.Errorf("%s thresholds %q = %g, %g, %v", tt.check.name, tt.value, warn, crit, err)
+		}
+	}
+
+	if worseState(healthWarn, healthUnknown) != healthUnknown || worseState(healthCrit, healthUnknown) != healthCrit {
+		t.Error("unknown should be worse than warn but not crit")
+	}
+}
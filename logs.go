@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// how often to poll the device for new log lines
+const logPollInterval = time.Second
+
+// structure of responses to weblog
+type WebLogResponse struct {
+	WebLog int `json:"WebLog"`
+}
+
+// display the log of a device, optionally following it
+func runLogs(dev Device) error {
+	// temporarily raise the web log level, restoring the original on exit
+	if viper.IsSet("level") {
+		level := viper.GetInt("level")
+		if level < 0 || level > 4 {
+			return fmt.Errorf("log level \"%d\" is invalid, must be 0-4", level)
+		}
+
+		original, err := setWebLog(dev, level)
+		if err != nil {
+			return err
+		}
+
+		if verbose {
+			fmt.Printf("WebLog: %d, was %d\n", level, original)
+		}
+
+		defer func() {
+			if _, err := setWebLog(dev, original); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: could not restore WebLog to %d: %s\n", original, err)
+			}
+		}()
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	index := 0
+	for {
+		lines, next, err := readWebLog(dev, index)
+		if err != nil {
+			if !viper.GetBool("follow") {
+				return err
+			}
+			// keep following, the device may come back
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		}
+		index = next
+
+		for _, line := range lines {
+			fmt.Printf("%s %s\n", time.Now().Format("2006-01-02 15:04:05"), line)
+		}
+
+		if !viper.GetBool("follow") {
+			return nil
+		}
+
+		select {
+		case <-interrupt:
+			return nil
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+// set the web log level, returning the previous level
+func setWebLog(dev Device, level int) (int, error) {
+	response, err := sendTasmota(dev, "WebLog")
+	if err != nil {
+		return 0, err
+	}
+
+	original := WebLogResponse{}
+	if err := decodeResponse(dev.Host, response, &original); err != nil {
+		return 0, err
+	}
+
+	if _, err := sendTasmota(dev, fmt.Sprintf("WebLog%%20%d", level)); err != nil {
+		return 0, err
+	}
+
+	return original.WebLog, nil
+}
+
+// read log lines from the web console buffer, starting after index
+//
+// the response looks like: <next index>}1<reset flag>}1<lines separated by \n>}1
+func readWebLog(dev Device, index int) ([]string, int, error) {
+	response, err := getTasmota(dev, fmt.Sprintf("/cs?c2=%d", index))
+	if err != nil {
+		return nil, index, err
+	}
+
+	parts := strings.Split(string(response), "}1")
+	if len(parts) < 3 {
+		return nil, index, &DeviceError{Kind: ErrHTTP, Address: dev.Host, Err: errors.New("unexpected web log response")}
+	}
+
+	next, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, index, &DeviceError{Kind: ErrHTTP, Address: dev.Host, Err: err}
+	}
+
+	var lines []string
+	for _, line := range strings.Split(parts[2], "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, next, nil
+}
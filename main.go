@@ -2,21 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
-	"text/tabwriter"
 	"time"
 
-	"github.com/spf13/pflag"
+	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
@@ -27,7 +26,12 @@ const applicationUrl string = "https://github.com/smford/tasmota-cli"
 var (
 	verbose     bool
 	homeDirName string
-	ipofdevice  string
+
+	// where requests print their verbose lines, the console's terminal while it is in raw mode
+	verboseOut io.Writer = os.Stdout
+
+	// shared by all requests, timeouts are set per request
+	httpClient = &http.Client{}
 
 	commandList = map[string]string{
 		"on":        `Power%20On`,
@@ -144,10 +148,8 @@ type StatusResponse struct {
 		Sunrise  string `json:"Sunrise"`
 		Sunset   string `json:"Sunset"`
 	} `json:"StatusTIM"`
-	StatusSNS struct {
-		Time    string `json:"Time"`
-		Switch1 string `json:"Switch1"`
-	} `json:"StatusSNS"`
+	// sensors differ between devices, e.g. ENERGY, DS18B20 or Switch1
+	StatusSNS map[string]interface{} `json:"StatusSNS"`
 	StatusSTS struct {
 		Time      string `json:"Time"`
 		Uptime    string `json:"Uptime"`
@@ -342,45 +344,38 @@ type AllTimers struct {
 	} `json:"Timer16"`
 }
 
-func init() {
-
-	homeDirName, err := os.UserHomeDir()
-	checkErr(err)
-
-	flag.String("cmd", "", "Command: on, off, status, statusall, timers")
-	flag.String("config", homeDirName+"/.tascli", "Configuration file: /path/to/file.yaml, default = "+homeDirName+"/.tascli")
-	flag.String("custom", "", "Custom escaped command string to send")
-	flag.String("device", "", "Device")
-	flag.Bool("displayconfig", false, "Display configuration")
-	flag.Bool("help", false, "Help")
-	flag.String("host", "", "IP address or hostname of a device")
-	flag.Bool("json", false, "Output JSON")
-	flag.Bool("list", false, "List Devices")
-	flag.Bool("version", false, "Version")
-
-	// temp
-	flag.Bool("verbose", false, "Be verbose")
-
-	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
-	pflag.Parse()
-	err = viper.BindPFlags(pflag.CommandLine)
-
-	checkErr(err)
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		exitWithError(err)
+	}
+}
 
-	viper.SetEnvPrefix("TASCLI")
-	err = viper.BindEnv("config")
-	checkErr(err)
+// check --output, --format and --select are usable before talking to any device
+func checkOutputFlags() error {
+	if viper.IsSet("format") {
+		if viper.IsSet("output") || viper.GetBool("json") {
+			return errors.New("--format cannot be used with --output or --json")
+		}
+		if _, err := parseTemplate(viper.GetString("format")); err != nil {
+			return fmt.Errorf("format is invalid: %s", err)
+		}
+	}
 
-	if viper.GetBool("help") {
-		displayHelp()
-		os.Exit(0)
+	if viper.IsSet("select") {
+		if _, err := parseSelect(viper.GetString("select")); err != nil {
+			return err
+		}
 	}
 
-	if viper.GetBool("version") {
-		fmt.Println(applicationName + " " + applicationVersion)
-		os.Exit(0)
+	if viper.IsSet("output") && !isOutputValid(viper.GetString("output")) {
+		return fmt.Errorf("output format \"%s\" is invalid, must be one of: %s", viper.GetString("output"), strings.Join(outputFormats, ", "))
 	}
 
+	return nil
+}
+
+// read the configuration file, which is optional unless one was asked for
+func loadConfig() error {
 	configdir, configfile := filepath.Split(viper.GetString("config"))
 
 	// set default configuration directory to current directory
@@ -396,214 +391,261 @@ func init() {
 
 	viper.SetConfigName(config)
 
-	err = viper.ReadInConfig()
-	checkErr(err)
+	if err := viper.ReadInConfig(); err != nil {
+		// everything needed may be on the command line, e.g. --host
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return err
+		}
+		if flagChanged("config") || os.Getenv("TASCLI_CONFIG") != "" {
+			return &ConfigNotFoundError{Path: viper.GetString("config")}
+		}
+		if verbose {
+			fmt.Println("No configuration file found")
+		}
+	}
+	return nil
+}
 
+// run the old --cmd, --custom, --list and --displayconfig flags
+func runLegacy(cmd *cobra.Command, args []string) error {
 	if viper.GetBool("displayconfig") {
-		displayConfig()
-		os.Exit(0)
+		return displayConfig()
 	}
 
 	if viper.GetBool("list") {
-		displayDevices()
-		os.Exit(0)
+		return displayDevices()
 	}
-}
 
-func main() {
-	// temp
-	verbose = viper.GetBool("verbose")
+	if (!viper.IsSet("custom")) && (!viper.IsSet("cmd")) {
+		return cmd.Help()
+	}
 
 	// prevent conflicting arguments from breaking logic
 	if (viper.IsSet("custom")) && (viper.IsSet("cmd")) {
-		fmt.Println("--custom or --cmd cannot be used at the same time")
-		os.Exit(1)
+		return errors.New("--custom or --cmd cannot be used at the same time")
 	}
 
-	if (!viper.IsSet("custom")) && (!viper.IsSet("cmd")) {
-		fmt.Println("either --custom or --cmd must be set")
-		os.Exit(1)
+	if (!viper.IsSet("device")) && (!viper.IsSet("host")) && (!viper.IsSet("group")) {
+		return errors.New("either --device, --host or --group must be set")
 	}
 
-	if (viper.IsSet("device")) && (viper.IsSet("host")) {
-		fmt.Println("--device and --host cannot be used at the same time")
-		os.Exit(1)
+	// check if command is valid
+	cleanCommand := strings.ToLower(viper.GetString("cmd"))
+	if viper.IsSet("cmd") && !isCommandValid(cleanCommand) {
+		return fmt.Errorf("command \"%s\" is invalid", viper.GetString("cmd"))
 	}
 
-	if (!viper.IsSet("device")) && (!viper.IsSet("host")) {
-		fmt.Println("either --device or --host must be set")
-		os.Exit(1)
+	devices, group, err := resolveTargets(nil)
+	if err != nil {
+		return err
 	}
 
-	// command to actually send to tasmota
-	var sendCommand string
+	// power commands work on groups and individual relays
+	if cleanCommand == "on" || cleanCommand == "off" || cleanCommand == "status" {
+		return runPower(devices, cleanCommand, group)
+	}
 
-	// prep custom command to send
-	if viper.IsSet("custom") {
-		if verbose {
-			fmt.Printf("Custom Command: %s\n", viper.GetString("custom"))
-			fmt.Printf("Custom Command Escaped: %s\n", url.QueryEscape(viper.GetString("custom")))
-		}
-		sendCommand = url.QueryEscape(viper.GetString("custom"))
-	}
-
-	// prep cmd to send
-	if viper.IsSet("cmd") {
-		// check if command is valid
-		if isCommandValid(viper.GetString("cmd")) {
-			// convert shorthand cmd to actual tasmota compatable command
-			sendCommand = commandList[viper.GetString("cmd")]
-		} else {
-			fmt.Printf("Command \"%s\" is invalid\n", viper.GetString("cmd"))
-			os.Exit(1)
-		}
+	if group {
+		return errors.New("--group can only be used with --cmd on, off or status")
 	}
 
-	if viper.IsSet("host") {
-		ipofdevice = viper.GetString("host")
-	} else {
-		// check if device is valid
-		if checkDeviceValid(viper.GetString("device")) {
-			if verbose {
-				fmt.Printf("Device: %s found\n", viper.GetString("device"))
-			}
+	switch cleanCommand {
+	case "statusall":
+		return runStatusAll(devices[0])
+	case "timers":
+		return runTimers(devices[0])
+	}
 
-			ipofdevice = viper.GetStringMap("devices")[viper.GetString("device")].(string)
+	return runSend(devices[0], viper.GetString("custom"))
+}
 
-		} else {
-			fmt.Printf("Device: %s not found\n", viper.GetString("device"))
-			os.Exit(1)
-		}
+// send any command and display the response as it is
+func runSend(dev Device, command string) error {
+	if verbose {
+		fmt.Printf("Custom Command: %s\n", command)
+		fmt.Printf("Custom Command Escaped: %s\n", url.QueryEscape(command))
 	}
 
-	// send the command to tasmota
-	response, success := sendTasmota(ipofdevice, sendCommand)
+	response, err := sendTasmota(dev, url.QueryEscape(command))
+	if err != nil {
+		return err
+	}
 
-	if !success {
-		// could not send to tasmota
-		fmt.Println("Error: Could not connect to device")
-		os.Exit(1)
-	} else {
+	if verbose {
+		fmt.Printf("Successful Response: %s\n", string(response))
+	}
 
-		if verbose {
-			fmt.Printf("Successful Response: %s\n", prettyPrint(response))
-		}
+	// as response will be in an unknown json format, keep it as it is
+	o, err := customOutput(response)
+	if err != nil {
+		return err
+	}
+	o.Plugin.Message = fmt.Sprintf("%s replied to %s", dev.Name, command)
+	return render(os.Stdout, o)
+}
 
-		// if custom command was sent
-		if viper.IsSet("custom") {
-			// as response will be in an unknown json format, just make pretty indents and dump to console
-			var niceJSON bytes.Buffer
-			error := json.Indent(&niceJSON, response, "", "\t")
-			checkErr(error)
-			fmt.Println(string(niceJSON.Bytes()))
-			os.Exit(0)
-		}
+// display the full status of a device
+func runStatusAll(dev Device) error {
+	response, err := sendTasmota(dev, commandList["statusall"])
+	if err != nil {
+		return err
+	}
 
-		// if baked in cmd was sent
-		if viper.IsSet("cmd") {
-
-			cleanCommand := strings.ToLower(viper.GetString("cmd"))
-
-			// start: if power on or power off
-			if strings.EqualFold(cleanCommand, "on") || strings.EqualFold(cleanCommand, "off") {
-				res := PowerResponse{}
-				err := json.Unmarshal(response, &res)
-				checkErr(err)
-				fmt.Printf("%s:%s\n", viper.GetString("device"), res.Power)
-				os.Exit(0)
-			}
-			// end: if power on or power off
-
-			// start: if status or statusall
-			if strings.EqualFold(cleanCommand, "status") || strings.EqualFold(cleanCommand, "statusall") {
-				res := StatusResponse{}
-				err := json.Unmarshal(response, &res)
-				checkErr(err)
-
-				// if status
-				if strings.EqualFold(cleanCommand, "status") {
-					var powerState string
-					switch res.Status.Power {
-					case 0:
-						powerState = "OFF"
-					case 1:
-						powerState = "ON"
-					default:
-						powerState = "UNKNOWN"
-					}
-
-					fmt.Printf("%s\n", powerState)
-					os.Exit(0)
-				}
-
-				// if statusall
-				if strings.EqualFold(cleanCommand, "statusall") {
-					fmt.Printf("%s\n", prettyPrint(res))
-					os.Exit(0)
-				}
-			}
-			// end: if status or statusall
-
-			// start: if timers
-			if strings.EqualFold(cleanCommand, "timers") {
-				res := AllTimers{}
-				err := json.Unmarshal(response, &res)
-				checkErr(err)
-
-				// if wanting json output
-				if viper.GetBool("json") {
-					fmt.Printf("%s\n", prettyPrint(res))
-					os.Exit(0)
-				} else {
-
-					// if wanting console output
-					printTimers(res)
-					os.Exit(0)
-				}
-				// end: if timers
-
-			}
-		}
+	res := StatusResponse{}
+	if err := decodeResponse(dev.Host, response, &res); err != nil {
+		return err
 	}
+
+	// keep the whole response, as the status only describes part of it
+	o, err := fieldsOutput(json.RawMessage(response), response)
+	if err != nil {
+		return err
+	}
+	o.Plugin.Message = fmt.Sprintf("%s is up %s, firmware %s", dev.Name, res.StatusSTS.Uptime, res.StatusFWR.Version)
+	return render(os.Stdout, o)
+}
+
+// display the timers of a device
+func runTimers(dev Device) error {
+	response, err := sendTasmota(dev, commandList["timers"])
+	if err != nil {
+		return err
+	}
+
+	res := AllTimers{}
+	if err := decodeResponse(dev.Host, response, &res); err != nil {
+		return err
+	}
+	return render(os.Stdout, timersOutput(res, response))
 }
 
 // send a command to the tasmota
-func sendTasmota(ip string, cmd string) ([]byte, bool) {
+func sendTasmota(dev Device, cmd string) ([]byte, error) {
+	// only retry commands that change state when asked to
+	retry := isReadCommand(cmd) || viper.GetBool("retry-writes")
 
-	url := fmt.Sprintf("http://%s/cm?cmnd=%s", ip, cmd)
+	response, err := requestTasmota(dev, "/cm?cmnd="+cmd, retry)
+	if err != nil {
+		return nil, err
+	}
 
-	if verbose {
-		fmt.Printf("URL: %s\n", url)
+	// tasmota replies with {"Command":"Unknown"} to commands it doesn't know
+	var unknown struct {
+		Command string `json:"Command"`
+	}
+	if json.Unmarshal(response, &unknown) == nil && unknown.Command == "Unknown" {
+		return response, &DeviceError{Kind: ErrUnknownCommand, Address: dev.Host}
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	return response, nil
+}
 
-	// fix
-	//if err != nil {
-	//	log.Fatal("NewRequest: ", err)
-	//}
-	checkErr(err)
+// make a read only request to a path on the tasmota web server
+func getTasmota(dev Device, path string) ([]byte, error) {
+	return requestTasmota(dev, path, true)
+}
 
-	client := &http.Client{}
-	client.Timeout = time.Second * 5
-	resp, err := client.Do(req)
-	checkErr(err)
+// make a request to the tasmota web server, retrying with backoff if allowed
+func requestTasmota(dev Device, path string, retry bool) ([]byte, error) {
+	attempts := 1
+	if retry {
+		attempts += dev.Retries
+	}
 
-	defer resp.Body.Close()
+	for attempt := 1; ; attempt++ {
+		response, err := fetchTasmota(dev, path)
+		if err == nil || attempt >= attempts || !isRetryable(err) {
+			return response, err
+		}
 
-	wassucessful := false
-	if resp.StatusCode == http.StatusOK {
+		wait := retryDelay(dev.RetryBackoff, attempt)
 		if verbose {
-			fmt.Println("http status = ok")
+			fmt.Fprintf(verboseOut, "Retry %d/%d for %s in %s: %s\n", attempt, attempts-1, dev.Name, wait.Round(time.Millisecond), err)
 		}
-		wassucessful = true
+		time.Sleep(wait)
+	}
+}
+
+// make a single request to a path on the tasmota web server
+func fetchTasmota(dev Device, path string) ([]byte, error) {
+
+	url := fmt.Sprintf("http://%s%s", dev.Host, path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), dev.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, &DeviceError{Kind: ErrUnreachable, Address: dev.Host, Err: err}
+	}
+
+	if err := addCredentials(dev, req); err != nil {
+		return nil, err
+	}
+
+	// commands such as WebPassword and MqttPassword have secrets in the url
+	if verbose {
+		fmt.Fprintf(verboseOut, "URL: %s\n", redactURL(req.URL))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, connectionError(dev.Host, err)
 	}
 
+	defer resp.Body.Close()
+
 	bodyBytes, err := io.ReadAll(resp.Body)
-	checkErr(err)
+	if err != nil {
+		return nil, connectionError(dev.Host, err)
+	}
+
+	// tasmota replies with {"WARNING":"Need user=<username>&password=<password>"} when a password is set
+	if resp.StatusCode == http.StatusUnauthorized || bytes.Contains(bodyBytes, []byte("Need user=")) {
+		return nil, &DeviceError{Kind: ErrAuthRequired, Address: dev.Host, Status: resp.StatusCode}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &DeviceError{Kind: ErrHTTP, Address: dev.Host, Status: resp.StatusCode}
+	}
+
+	if verbose {
+		fmt.Fprintln(verboseOut, "http status = ok")
+	}
+
+	return bodyBytes, nil
+
+}
+
+// add the user and password of a device to a request, commands take them as parameters and
+// everything else uses basic auth
+func addCredentials(dev Device, req *http.Request) error {
+	if dev.Password == nil {
+		return nil
+	}
 
-	return bodyBytes, wassucessful
+	password, err := dev.Password.Value()
+	if err != nil {
+		return fmt.Errorf("%s: %s", dev.Name, err)
+	}
 
+	if req.URL.Path != "/cm" {
+		req.SetBasicAuth(dev.User, password)
+		return nil
+	}
+
+	// cmnd is already escaped, so add to the raw query rather than re-encoding it
+	req.URL.RawQuery = "user=" + url.QueryEscape(dev.User) + "&password=" + url.QueryEscape(password) + "&" + req.URL.RawQuery
+	return nil
+}
+
+// decode a json response from a device
+func decodeResponse(ip string, response []byte, v interface{}) error {
+	if err := json.Unmarshal(response, v); err != nil {
+		return &DeviceError{Kind: ErrInvalidJSON, Address: ip, Err: err}
+	}
+	return nil
 }
 
 // prints out json pretty
@@ -621,82 +663,43 @@ func isCommandValid(command string) bool {
 	return false
 }
 
-// display help
-func displayHelp() {
-	message := `
-      --cmd [x]             Commands: on, off, status, statusall, timers
-      --config [file]       Configuration file: /path/to/file.yaml, default = "` + homeDirName + `"/.tascli"
-      --custom [command]    Custom escaped command string to send
-      --device [name]       Name of device
-      --displayconfig       Display configuration
-      --help                Display help
-      --host [address]      IP address or hostname of device
-      --json                Output JSON
-      --list                List all configured devices
-      --verbose             Be verbose
-      --version             Display version`
-	fmt.Println(applicationName + " " + applicationVersion + "\n" + applicationUrl)
-	fmt.Println(message)
-}
-
-// captures and prints errors
+// captures and prints errors, exiting with the exit code for the error
 func checkErr(err error) {
 	if err != nil {
-		log.Fatal(err)
+		exitWithError(err)
 	}
 }
 
 // display configuration
-func displayConfig() {
-	allmysettings := viper.AllSettings()
-	var keys []string
-	for k := range allmysettings {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	w := new(tabwriter.Writer)
-
-	const padding = 1
-	w.Init(os.Stdout, 0, 2, padding, ' ', 0)
-	defer w.Flush()
-
-	fmt.Fprintf(w, "%s\t%s\n", "Config", "Setting")
-	fmt.Fprintf(w, "%s\t%s\n", "------", "-------")
-
-	for _, k := range keys {
-		fmt.Fprintf(w, "%s\t%v\n", k, allmysettings[k])
-	}
+func displayConfig() error {
+	return render(os.Stdout, configOutput())
 }
 
-// list devices
-func displayDevices() {
-	if viper.IsSet("devices") {
-		// do sorting
-		var keys []string
-		for k := range viper.GetStringMap("devices") {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-
-		// print the list
-		w := new(tabwriter.Writer)
-
-		const padding = 1
-		w.Init(os.Stdout, 0, 2, padding, ' ', 0)
-		defer w.Flush()
-
-		fmt.Fprintf(w, "%s\t%s\n", "IP", "Name")
-		fmt.Fprintf(w, "%s\t%s\n", "--", "----")
-
-		for _, k := range keys {
-			fmt.Fprintf(w, "%s\t%s\n", viper.GetStringMap("devices")[k].(string), k)
+// list devices, of every profile if --all-profiles is set
+func displayDevices() error {
+	if viper.GetBool("all-profiles") {
+		devices, err := allProfileDevices()
+		if err != nil {
+			return err
 		}
-		fmt.Println("======")
+		return render(os.Stdout, profileDevicesOutput(devices))
+	}
 
-	} else {
+	if !viper.IsSet("devices") {
 		fmt.Println("no devices found")
+		return nil
 	}
+	return render(os.Stdout, devicesOutput())
+}
+
+// sorted keys of a map
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // check that the device exists
@@ -708,35 +711,3 @@ func checkDeviceValid(device string) bool {
 		return false
 	}
 }
-
-// print all timers
-func printTimers(myTimers AllTimers) {
-	w := new(tabwriter.Writer)
-
-	const padding = 1
-	w.Init(os.Stdout, 0, 2, padding, ' ', 0)
-	defer w.Flush()
-
-	// forgive me, this is gross
-	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "Name", "Enabled", "Mode", "Time", "Window", "Days", "Repeat", "Output", "Action")
-	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "-------", "-------", "----", "-----", "------", "-------", "------", "------", "------")
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer1", myTimers.Timer1.Enable, myTimers.Timer1.Mode, myTimers.Timer1.Time, myTimers.Timer1.Window, myTimers.Timer1.Days, myTimers.Timer1.Repeat, myTimers.Timer1.Output, myTimers.Timer1.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer2", myTimers.Timer2.Enable, myTimers.Timer2.Mode, myTimers.Timer2.Time, myTimers.Timer2.Window, myTimers.Timer2.Days, myTimers.Timer2.Repeat, myTimers.Timer2.Output, myTimers.Timer2.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer3", myTimers.Timer3.Enable, myTimers.Timer3.Mode, myTimers.Timer3.Time, myTimers.Timer3.Window, myTimers.Timer3.Days, myTimers.Timer3.Repeat, myTimers.Timer3.Output, myTimers.Timer3.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer4", myTimers.Timer4.Enable, myTimers.Timer4.Mode, myTimers.Timer4.Time, myTimers.Timer4.Window, myTimers.Timer4.Days, myTimers.Timer4.Repeat, myTimers.Timer4.Output, myTimers.Timer4.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer5", myTimers.Timer5.Enable, myTimers.Timer5.Mode, myTimers.Timer5.Time, myTimers.Timer5.Window, myTimers.Timer5.Days, myTimers.Timer5.Repeat, myTimers.Timer5.Output, myTimers.Timer5.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer5", myTimers.Timer5.Enable, myTimers.Timer5.Mode, myTimers.Timer5.Time, myTimers.Timer5.Window, myTimers.Timer5.Days, myTimers.Timer5.Repeat, myTimers.Timer5.Output, myTimers.Timer5.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer6", myTimers.Timer6.Enable, myTimers.Timer6.Mode, myTimers.Timer6.Time, myTimers.Timer6.Window, myTimers.Timer6.Days, myTimers.Timer6.Repeat, myTimers.Timer6.Output, myTimers.Timer6.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer7", myTimers.Timer7.Enable, myTimers.Timer7.Mode, myTimers.Timer7.Time, myTimers.Timer7.Window, myTimers.Timer7.Days, myTimers.Timer7.Repeat, myTimers.Timer7.Output, myTimers.Timer7.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer8", myTimers.Timer8.Enable, myTimers.Timer8.Mode, myTimers.Timer8.Time, myTimers.Timer8.Window, myTimers.Timer8.Days, myTimers.Timer8.Repeat, myTimers.Timer8.Output, myTimers.Timer8.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer9", myTimers.Timer9.Enable, myTimers.Timer9.Mode, myTimers.Timer9.Time, myTimers.Timer9.Window, myTimers.Timer9.Days, myTimers.Timer9.Repeat, myTimers.Timer9.Output, myTimers.Timer9.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer10", myTimers.Timer10.Enable, myTimers.Timer10.Mode, myTimers.Timer10.Time, myTimers.Timer10.Window, myTimers.Timer10.Days, myTimers.Timer10.Repeat, myTimers.Timer10.Output, myTimers.Timer10.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer11", myTimers.Timer11.Enable, myTimers.Timer11.Mode, myTimers.Timer11.Time, myTimers.Timer11.Window, myTimers.Timer11.Days, myTimers.Timer11.Repeat, myTimers.Timer11.Output, myTimers.Timer11.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer12", myTimers.Timer12.Enable, myTimers.Timer12.Mode, myTimers.Timer12.Time, myTimers.Timer12.Window, myTimers.Timer12.Days, myTimers.Timer12.Repeat, myTimers.Timer12.Output, myTimers.Timer12.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer13", myTimers.Timer13.Enable, myTimers.Timer13.Mode, myTimers.Timer13.Time, myTimers.Timer13.Window, myTimers.Timer13.Days, myTimers.Timer13.Repeat, myTimers.Timer13.Output, myTimers.Timer13.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer14", myTimers.Timer14.Enable, myTimers.Timer14.Mode, myTimers.Timer14.Time, myTimers.Timer14.Window, myTimers.Timer14.Days, myTimers.Timer14.Repeat, myTimers.Timer14.Output, myTimers.Timer14.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer15", myTimers.Timer15.Enable, myTimers.Timer15.Mode, myTimers.Timer15.Time, myTimers.Timer15.Window, myTimers.Timer15.Days, myTimers.Timer15.Repeat, myTimers.Timer15.Output, myTimers.Timer15.Action)
-	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t%d\n", "Timer16", myTimers.Timer16.Enable, myTimers.Timer16.Mode, myTimers.Timer16.Time, myTimers.Timer16.Window, myTimers.Timer16.Days, myTimers.Timer16.Repeat, myTimers.Timer16.Output, myTimers.Timer16.Action)
-
-	fmt.Println("\nFurther details available here: https://tasmota.github.io/docs/Timers/#json-payload-anatomy\n")
-}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var mqttCmd = &cobra.Command{
+	Use:   "mqtt",
+	Short: "Show, change and check the mqtt settings of devices",
+}
+
+var mqttShowCmd = &cobra.Command{
+	Use:   "show [device|group]",
+	Short: "Show the mqtt settings of a device or group, or all devices",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		devices, group, err := resolveTargetsOrAll(args)
+		if err != nil {
+			return err
+		}
+		return runMqttShow(devices, group)
+	},
+}
+
+var mqttSetCmd = &cobra.Command{
+	Use:   "set [device|group]",
+	Short: "Set the mqtt broker, credentials and topics of a device or group",
+	Long: `Set the mqtt broker, credentials and topics of a device or group. Devices restart to use
+the new settings.
+
+Devices can't share a topic, so --topic can only be given to more than one device if it has a
+placeholder such as %06X, which tasmota replaces with the end of the device's mac address.
+As --host and --user are the broker's here, give the device as an argument.
+
+The password can refer to an environment variable, e.g. '${MQTT_PASSWORD}', to keep it out of
+the shell history.`,
+	Example: `  tasmota-cli mqtt set lamp --host mqtt.home --user tasmota --password '${MQTT_PASSWORD}'
+  tasmota-cli mqtt set downstairs --fulltopic '%prefix%/%topic%/' --grouptopic downstairs`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		devices, group, err := resolveTargets(args)
+		if err != nil {
+			return err
+		}
+		settings, err := mqttSettings(cmd)
+		if err != nil {
+			return err
+		}
+		return runMqttSet(devices, group, settings)
+	},
+}
+
+var mqttAuditCmd = &cobra.Command{
+	Use:   "audit [device|group]",
+	Short: "Check devices use the right mqtt broker and don't share topics",
+	Long: `Check the mqtt settings of a device or group, or all devices, finding devices that:
+
+  use a different broker to the one given by --broker or the broker setting, or to the broker
+  most devices use if neither is set
+  have a broker but aren't connected to it
+  share a topic or client id with another device, so their messages get mixed up
+
+Exits with 1 if any problems are found.`,
+	Example: `  tasmota-cli mqtt audit
+  tasmota-cli mqtt audit downstairs --broker mqtt.home:1883`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		devices, group, err := resolveTargetsOrAll(args)
+		if err != nil {
+			return err
+		}
+		return runMqttAudit(devices, group)
+	},
+}
+
+func init() {
+	flags := mqttSetCmd.Flags()
+	flags.String("host", "", "Address of the mqtt broker")
+	flags.Int("port", 1883, "Port of the mqtt broker")
+	flags.String("user", "", "User to connect to the broker with")
+	flags.String("password", "", "Password to connect to the broker with")
+	flags.String("topic", "", "Topic of the device, e.g. lamp or tasmota_%06X")
+	flags.String("fulltopic", "", "Full topic, e.g. %prefix%/%topic%/")
+	flags.String("grouptopic", "", "Group topic shared by devices to be controlled together")
+	// these are also the device's address and credentials, so keep them from viper
+	for _, name := range []string{"host", "user", "password"} {
+		unbindFlag(flags, name)
+	}
+
+	mqttAuditCmd.Flags().String("broker", "", "Broker devices should use as host[:port]")
+
+	mqttCmd.AddCommand(mqttShowCmd, mqttSetCmd, mqttAuditCmd)
+	rootCmd.AddCommand(mqttCmd)
+}
+
+// the mqtt settings of a device
+type MqttResult struct {
+	Device     string `json:"Device"`
+	Host       string `json:"Host"`
+	Port       int    `json:"Port"`
+	Client     string `json:"Client"`
+	User       string `json:"User"`
+	Topic      string `json:"Topic"`
+	GroupTopic string `json:"GroupTopic"`
+	Count      int    `json:"Count"`
+}
+
+// the broker of a device as host:port
+func (r MqttResult) broker() string {
+	return net.JoinHostPort(r.Host, strconv.Itoa(r.Port))
+}
+
+// read the mqtt settings of devices at the same time, leaving out devices that can't be read
+func readMqttAll(devices []Device, group bool) ([]MqttResult, error) {
+	results := make([]MqttResult, len(devices))
+	errs := queryDevices(devices, func(i int, dev Device) error {
+		var err error
+		results[i], err = readMqtt(dev)
+		return err
+	})
+
+	var read []MqttResult
+	var failed error
+	failures := 0
+	for i, err := range errs {
+		if err == nil {
+			read = append(read, results[i])
+			continue
+		}
+		if !group {
+			return nil, err
+		}
+		// carry on with the rest of the devices
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		if failed == nil {
+			failed = err
+		}
+		failures++
+	}
+
+	if failed != nil {
+		return read, &GroupError{Failures: failures, Total: len(devices), Err: failed}
+	}
+	return read, nil
+}
+
+// show the mqtt settings of devices
+func runMqttShow(devices []Device, group bool) error {
+	results, readErr := readMqttAll(devices, group)
+	if readErr != nil && len(results) == 0 {
+		return readErr
+	}
+	if err := render(os.Stdout, mqttOutput(results)); err != nil {
+		return err
+	}
+	return readErr
+}
+
+// read the mqtt settings of a device from its status
+func readMqtt(dev Device) (MqttResult, error) {
+	response, err := sendTasmota(dev, commandList["statusall"])
+	if err != nil {
+		return MqttResult{}, err
+	}
+
+	res := StatusResponse{}
+	if err := decodeResponse(dev.Host, response, &res); err != nil {
+		return MqttResult{}, err
+	}
+
+	return MqttResult{
+		Device:     dev.Name,
+		Host:       res.StatusMQT.MqttHost,
+		Port:       res.StatusMQT.MqttPort,
+		Client:     res.StatusMQT.MqttClient,
+		User:       res.StatusMQT.MqttUser,
+		Topic:      res.Status.Topic,
+		GroupTopic: res.StatusPRM.GroupTopic,
+		Count:      res.StatusSTS.MqttCount,
+	}, nil
+}
+
+// output of the mqtt settings of devices
+func mqttOutput(results []MqttResult) output {
+	o := output{
+		Data:    results,
+		Columns: []string{"Device", "Host", "Port", "Client", "User", "Topic", "GroupTopic", "Count"},
+	}
+	for _, r := range results {
+		o.Rows = append(o.Rows, []string{
+			r.Device, r.Host, strconv.Itoa(r.Port), r.Client, r.User, r.Topic, r.GroupTopic, strconv.Itoa(r.Count),
+		})
+	}
+	return o
+}
+
+// the settings given to mqtt set, in the order they are sent
+func mqttSettings(cmd *cobra.Command) ([]networkSetting, error) {
+	var settings []networkSetting
+	flags := cmd.Flags()
+
+	commands := []struct{ flag, command string }{
+		{"host", "MqttHost"},
+		{"port", "MqttPort"},
+		{"user", "MqttUser"},
+		{"password", "MqttPassword"},
+		{"topic", "Topic"},
+		{"fulltopic", "FullTopic"},
+		{"grouptopic", "GroupTopic1"},
+	}
+	for _, c := range commands {
+		if !flags.Changed(c.flag) {
+			continue
+		}
+		value := flags.Lookup(c.flag).Value.String()
+
+		switch c.flag {
+		case "port":
+			if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 65535 {
+				return nil, fmt.Errorf("--port %s is invalid, must be 1 to 65535", value)
+			}
+		case "password":
+			secret, err := secretFrom(map[string]interface{}{"password": value}, "password").Value()
+			if err != nil {
+				return nil, err
+			}
+			value = secret
+		}
+
+		// tasmota takes a single space or nothing as a request for the setting rather than a change
+		if strings.TrimSpace(value) == "" || strings.Contains(value, ";") {
+			return nil, fmt.Errorf("--%s is invalid, it can't be empty or contain a ;", c.flag)
+		}
+		settings = append(settings, networkSetting{c.command, value})
+	}
+
+	if len(settings) == 0 {
+		return nil, errors.New("nothing to set, use --host, --port, --user, --password, --topic, --fulltopic or --grouptopic")
+	}
+	return settings, nil
+}
+
+// send mqtt settings to devices
+func runMqttSet(devices []Device, group bool, settings []networkSetting) error {
+	var names, commands []string
+	for _, s := range settings {
+		names = append(names, s.command)
+		commands = append(commands, s.command+" "+s.value)
+
+		if s.command == "Topic" && !strings.Contains(s.value, "%") && (group || len(devices) > 1) {
+			return errors.New("devices can't share a topic, give --topic to a single device or use a placeholder such as tasmota_%06X")
+		}
+	}
+	backlog := "Backlog " + strings.Join(commands, "; ")
+
+	var failed error
+	failures := 0
+	for _, dev := range devices {
+		if _, err := sendTasmota(dev, url.QueryEscape(backlog)); err != nil {
+			if !group {
+				return err
+			}
+			// carry on with the rest of the group
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			if failed == nil {
+				failed = err
+			}
+			failures++
+			continue
+		}
+		fmt.Printf("%s: set %s, restarting\n", dev.Name, strings.Join(names, ", "))
+	}
+
+	if failed != nil {
+		return &GroupError{Failures: failures, Total: len(devices), Err: failed}
+	}
+	return nil
+}
+
+// check the mqtt settings of devices, returning an error if there are any problems
+func runMqttAudit(devices []Device, group bool) error {
+	results, readErr := readMqttAll(devices, group)
+	if readErr != nil && len(results) == 0 {
+		return readErr
+	}
+
+	problems := auditMqtt(results, viper.GetString("broker"))
+	if err := render(os.Stdout, auditOutput(problems)); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if len(problems) > 0 {
+		// the problems have been printed, so only the exit code is left
+		return &CheckError{State: healthWarn}
+	}
+	return nil
+}
+
+// find devices using the wrong broker, not connected to it, or sharing topics or client ids
+func auditMqtt(results []MqttResult, broker string) []AuditResult {
+	wantHost, wantPort := broker, ""
+	if broker == "" {
+		wantHost = mostUsedBroker(results)
+		broker = wantHost
+	} else if host, port, err := net.SplitHostPort(broker); err == nil {
+		wantHost, wantPort = host, port
+	}
+
+	var problems []AuditResult
+	for _, r := range results {
+		switch {
+		case r.Host == "":
+			if wantHost != "" {
+				problems = append(problems, AuditResult{r.Device, "doesn't use mqtt"})
+			}
+			continue
+		case wantHost != "" && !strings.EqualFold(r.Host, wantHost),
+			wantPort != "" && strconv.Itoa(r.Port) != wantPort:
+			problems = append(problems, AuditResult{r.Device, fmt.Sprintf("uses broker %s, not %s", r.broker(), broker)})
+		}
+		if r.Count == 0 {
+			problems = append(problems, AuditResult{r.Device, fmt.Sprintf("isn't connected to %s", r.broker())})
+		}
+	}
+
+	topics := map[string]string{}
+	clients := map[string]string{}
+	for _, r := range results {
+		topics[r.Device] = r.Topic
+		clients[r.Device] = r.Client
+	}
+	problems = append(problems, duplicates("topic", topics)...)
+	problems = append(problems, duplicates("client id", clients)...)
+	return problems
+}
+
+// the broker host most devices use, or none if there isn't one most use
+func mostUsedBroker(results []MqttResult) string {
+	counts := map[string]int{}
+	for _, r := range results {
+		if r.Host != "" {
+			counts[strings.ToLower(r.Host)]++
+		}
+	}
+
+	most, tied := "", false
+	for host, n := range counts {
+		switch {
+		case most == "" || n > counts[most]:
+			most, tied = host, false
+		case n == counts[most]:
+			tied = true
+		}
+	}
+	if tied {
+		return ""
+	}
+	return most
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditMqtt(t *testing.T) {
+	results := []MqttResult{
+		{Device: "lamp", Host: "mqtt.home", Port: 1883, Client: "DVES_000001", Topic: "lamp", Count: 1},
+		{Device: "strip", Host: "MQTT.home", Port: 1884, Client: "DVES_000002", Topic: "Lamp", Count: 2},
+		{Device: "heater", Host: "old.home", Port: 1883, Client: "DVES_000003", Topic: "heater", Count: 0},
+		{Device: "fan", Client: "DVES_000003", Topic: "fan"},
+	}
+
+	tests := []struct {
+		broker string
+		want   []AuditResult
+	}{
+		{"", []AuditResult{
+			{"heater", "uses broker old.home:1883, not mqtt.home"},
+			{"heater", "isn't connected to old.home:1883"},
+			{"fan", "doesn't use mqtt"},
+			{"lamp", "topic lamp is also used by strip"},
+			{"strip", "topic Lamp is also used by lamp"},
+			{"fan", "client id DVES_000003 is also used by heater"},
+			{"heater", "client id DVES_000003 is also used by fan"},
+		}},
+		{"mqtt.home:1883", []AuditResult{
+			{"strip", "uses broker MQTT.home:1884, not mqtt.home:1883"},
+			{"heater", "uses broker old.home:1883, not mqtt.home:1883"},
+			{"heater", "isn't connected to old.home:1883"},
+			{"fan", "doesn't use mqtt"},
+			{"lamp", "topic lamp is also used by strip"},
+			{"strip", "topic Lamp is also used by lamp"},
+			{"fan", "client id DVES_000003 is also used by heater"},
+			{"heater", "client id DVES_000003 is also used by fan"},
+		}},
+	}
+
+	for _, tt := range tests {
+		if got := auditMqtt(results, tt.broker); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("auditMqtt(%q) = %v, want %v", tt.broker, got, tt.want)
+		}
+	}
+
+	// with as many devices on each broker, there is no telling which is right
+	if got := mostUsedBroker(results[1:3]); got != "" {
+		t.Errorf("mostUsedBroker of a tie = %q", got)
+	}
+}
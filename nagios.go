@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// names of the states in nagios plugin output, the exit codes are the same as the health states
+var pluginStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// commands that can be used as nagios plugins with --nagios
+var pluginCommands = []string{"status", "health", "send"}
+
+// a result as a nagios plugin reports it
+type pluginResult struct {
+	State   int        // state before any -w and -c thresholds
+	Message string     // what was checked, e.g. lamp is ON
+	Perf    []perfData // performance data, added to by thresholds
+}
+
+// performance data, shown after the message as 'label'=value;warn;crit
+type perfData struct {
+	label string
+	value float64
+	warn  string
+	crit  string
+}
+
+func (p perfData) String() string {
+	label := p.label
+	if strings.ContainsAny(label, " '=") {
+		label = "'" + strings.ReplaceAll(label, "'", "''") + "'"
+	}
+	return fmt.Sprintf("%s=%s;%s;%s", label, strconv.FormatFloat(p.value, 'f', -1, 64), p.warn, p.crit)
+}
+
+// a nagios threshold range, alerting when a value is outside start:end, or inside it with @
+//
+//	10      outside 0:10
+//	10:     below 10
+//	~:10    above 10
+//	10:20   outside 10:20
+//	@10:20  inside 10:20
+type nagiosRange struct {
+	text   string
+	start  float64
+	end    float64
+	inside bool
+}
+
+// parse a threshold range as the nagios plugin guidelines describe
+func parseRange(text string) (nagiosRange, error) {
+	r := nagiosRange{text: text, end: math.Inf(1)}
+	invalid := fmt.Errorf("range \"%s\" is invalid, e.g. 10, 10:, ~:10, 10:20 or @10:20", text)
+	s := text
+	if strings.HasPrefix(s, "@") {
+		r.inside = true
+		s = s[1:]
+	}
+
+	if s == "" {
+		return r, invalid
+	}
+
+	start, end, hasStart := strings.Cut(s, ":")
+	if !hasStart {
+		start, end = "0", s
+	}
+
+	var err error
+	switch start {
+	case "~":
+		r.start = math.Inf(-1)
+	case "":
+		r.start = 0
+	default:
+		if r.start, err = strconv.ParseFloat(start, 64); err != nil {
+			return r, invalid
+		}
+	}
+	if end != "" {
+		if r.end, err = strconv.ParseFloat(end, 64); err != nil || r.end < r.start {
+			return r, invalid
+		}
+	}
+	return r, nil
+}
+
+// check if a value is alerted on
+func (r nagiosRange) alerts(value float64) bool {
+	outside := value < r.start || value > r.end
+	return outside != r.inside
+}
+
+// a field checked against warning and critical ranges given by -w and -c
+type pluginThreshold struct {
+	path     string
+	steps    []selectStep
+	warning  *nagiosRange
+	critical *nagiosRange
+}
+
+// the thresholds given by -w and -c as field=range, in the order the fields were first given
+func pluginThresholds() ([]*pluginThreshold, error) {
+	var thresholds []*pluginThreshold
+	byPath := map[string]*pluginThreshold{}
+
+	for _, flag := range []string{"warning", "critical"} {
+		for _, spec := range viper.GetStringSlice(flag) {
+			path, text, ok := strings.Cut(spec, "=")
+			if !ok || path == "" {
+				return nil, fmt.Errorf("--%s \"%s\" is invalid, must be field=range, e.g. StatusSNS.ENERGY.Power=2000", flag, spec)
+			}
+
+			t, ok := byPath[path]
+			if !ok {
+				steps, err := parseSelect(path)
+				if err != nil {
+					return nil, err
+				}
+				t = &pluginThreshold{path: path, steps: steps}
+				byPath[path] = t
+				thresholds = append(thresholds, t)
+			}
+
+			r, err := parseRange(text)
+			if err != nil {
+				return nil, err
+			}
+			if flag == "warning" {
+				t.warning = &r
+			} else {
+				t.critical = &r
+			}
+		}
+	}
+	return thresholds, nil
+}
+
+// check --nagios can be used with the command and its thresholds are valid
+func checkPluginFlags(command string) error {
+	if !viper.GetBool("nagios") {
+		if len(viper.GetStringSlice("warning")) > 0 || len(viper.GetStringSlice("critical")) > 0 {
+			return errors.New("--warning and --critical can only be used with --nagios")
+		}
+		return nil
+	}
+
+	if viper.IsSet("output") || viper.GetBool("json") || viper.IsSet("format") || viper.IsSet("select") {
+		return errors.New("--nagios cannot be used with --output, --json, --format or --select")
+	}
+
+	supported := false
+	for _, c := range pluginCommands {
+		supported = supported || c == command
+	}
+	if !supported {
+		return fmt.Errorf("--nagios can only be used with: %s", strings.Join(pluginCommands, ", "))
+	}
+
+	_, err := pluginThresholds()
+	return err
+}
+
+// nagios output was asked for, the arguments are checked too as bad flags are found before
+// viper has them
+func nagiosOutput() bool {
+	if viper.GetBool("nagios") {
+		return true
+	}
+	for _, arg := range os.Args[1:] {
+		if arg == "--nagios" || arg == "--nagios=true" {
+			return true
+		}
+	}
+	return false
+}
+
+// render output as a nagios plugin, a single line of STATE - message | perfdata, returning a
+// CheckError with the state if it isn't ok
+func renderNagios(w io.Writer, o output) error {
+	thresholds, err := pluginThresholds()
+	if err != nil {
+		return err
+	}
+
+	state := o.Plugin.State
+	messages := []string{o.Plugin.Message}
+	perf := o.Plugin.Perf
+
+	if len(thresholds) > 0 {
+		// the whole device response, so sensor readings such as StatusSNS.ENERGY.Power can be checked
+		data, err := o.source()
+		if err != nil {
+			return err
+		}
+
+		for _, t := range thresholds {
+			var matches []selectMatch
+			collectMatches(data, t.steps, "", &matches)
+			if len(matches) == 0 {
+				state = worseState(state, healthUnknown)
+				messages = append(messages, fmt.Sprintf("no fields match %s", t.path))
+				continue
+			}
+
+			for _, m := range matches {
+				value, ok := numericValue(m.value)
+				if !ok {
+					state = worseState(state, healthUnknown)
+					messages = append(messages, fmt.Sprintf("%s is not a number", m.path))
+					continue
+				}
+
+				p := perfData{label: m.path, value: value}
+				if t.warning != nil {
+					p.warn = t.warning.text
+				}
+				if t.critical != nil {
+					p.crit = t.critical.text
+				}
+				perf = append(perf, p)
+
+				switch {
+				case t.critical != nil && t.critical.alerts(value):
+					state = worseState(state, healthCrit)
+				case t.warning != nil && t.warning.alerts(value):
+					state = worseState(state, healthWarn)
+				default:
+					continue
+				}
+				messages = append(messages, fmt.Sprintf("%s is %s", m.path, strconv.FormatFloat(value, 'f', -1, 64)))
+			}
+		}
+	}
+
+	line := pluginStates[state] + " - " + strings.Join(messages, ", ")
+	if len(perf) > 0 {
+		var values []string
+		for _, p := range perf {
+			values = append(values, p.String())
+		}
+		line += " | " + strings.Join(values, " ")
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+
+	if state != healthOK {
+		return &CheckError{State: state}
+	}
+	return nil
+}
+
+// a number from a response, tasmota gives some numbers as strings
+func numericValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		// power states count as 1 and 0 so they can be checked too
+		switch strings.ToUpper(n) {
+		case "ON":
+			return 1, true
+		case "OFF":
+			return 0, true
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// print an error as a nagios plugin does, devices that can't be reached are critical and
+// anything else is unknown
+func exitPluginError(err error) {
+	state := healthUnknown
+	if errors.Is(err, ErrUnreachable) || errors.Is(err, ErrTimeout) {
+		state = healthCrit
+	}
+	fmt.Printf("%s - %s\n", pluginStates[state], err)
+	os.Exit(state)
+}
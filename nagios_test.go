@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		text   string
+		alerts []float64
+		passes []float64
+	}{
+		{"10", []float64{-1, 10.5, 11}, []float64{0, 5, 10}},
+		{"10:", []float64{-5, 9.9}, []float64{10, 1000}},
+		{"~:10", []float64{10.1, 20}, []float64{-1000, 0, 10}},
+		{"10:20", []float64{9, 21}, []float64{10, 15, 20}},
+		{"@10:20", []float64{10, 15, 20}, []float64{9, 21}},
+		{"-85:", []float64{-90}, []float64{-85, -60}},
+	}
+
+	for _, tt := range tests {
+		r, err := parseRange(tt.text)
+		if err != nil {
+			t.Errorf("parseRange(%q): %s", tt.text, err)
+			continue
+		}
+		for _, v := range tt.alerts {
+			if !r.alerts(v) {
+				t.Errorf("range %s doesn't alert on %g", tt.text, v)
+			}
+		}
+		for _, v := range tt.passes {
+			if r.alerts(v) {
+				t.Errorf("range %s alerts on %g", tt.text, v)
+			}
+		}
+	}
+
+	for _, text := range []string{"", "x", "20:10", "1:x", "@"} {
+		if _, err := parseRange(text); err == nil {
+			t.Errorf("parseRange(%q) didn't fail", text)
+		}
+	}
+}
+
+func TestPerfData(t *testing.T) {
+	tests := []struct {
+		perf perfData
+		want string
+	}{
+		{perfData{label: "heap", value: 26, warn: "15:", crit: "10:"}, "heap=26;15:;10:"},
+		{perfData{label: "StatusSNS.ENERGY.Power", value: 12.5, crit: "~:2000"}, "StatusSNS.ENERGY.Power=12.5;;~:2000"},
+		{perfData{label: "living room", value: 1}, "'living room'=1;;"},
+	}
+	for _, tt := range tests {
+		if got := tt.perf.String(); got != tt.want {
+			t.Errorf("perfData = %s, want %s", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var networkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "Change the wifi and network settings of devices",
+}
+
+var networkSetCmd = &cobra.Command{
+	Use:   "set [device|group]",
+	Short: "Set the wifi network, password and ip address of a device or group",
+	Long: `Set the wifi network and password, and the ip address, gateway, netmask and dns server of a
+device or group. Devices restart to use the new settings.
+
+A device that is given the wrong wifi network or password can't be reached to put it right, so
+changing them on more than one device at once needs --force. An ip address can only be given
+to a single device, and the configuration is changed to use it once the device has it. Use
+--ip dhcp to have the device get its address from dhcp again.
+
+The password can refer to an environment variable, e.g. '${WIFI_PASSWORD}', to keep it out of
+the shell history.`,
+	Example: `  tasmota-cli network set lamp --ip 192.168.1.50 --gateway 192.168.1.1 --netmask 255.255.255.0
+  tasmota-cli network set downstairs --ssid attic --password '${WIFI_PASSWORD}' --force
+  tasmota-cli network set lamp --ip dhcp`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		devices, group, err := resolveTargets(args)
+		if err != nil {
+			return err
+		}
+
+		// not bound to viper, which would use it as the password of devices
+		password, _ := cmd.Flags().GetString("password")
+		settings, err := networkSettings(password, cmd.Flags().Changed("password"))
+		if err != nil {
+			return err
+		}
+		return runNetworkSet(devices, group, settings)
+	},
+}
+
+func init() {
+	flags := networkSetCmd.Flags()
+	flags.String("ssid", "", "Wifi network to connect to")
+	flags.String("password", "", "Password of the wifi network")
+	flags.String("ip", "", "Static ip address, or dhcp")
+	flags.String("gateway", "", "Gateway of a static ip address")
+	flags.String("netmask", "", "Netmask of a static ip address, e.g. 255.255.255.0")
+	flags.String("dns", "", "Dns server of a static ip address")
+	flags.Bool("force", false, "Change the wifi network or password of more than one device")
+	unbindFlag(flags, "password")
+
+	networkCmd.AddCommand(networkSetCmd)
+	rootCmd.AddCommand(networkCmd)
+}
+
+// a setting sent to a device, e.g. IPAddress2 192.168.1.1
+type networkSetting struct {
+	command string
+	value   string
+}
+
+// the settings given to network set, in the order they are sent
+func networkSettings(password string, hasPassword bool) ([]networkSetting, error) {
+	var settings []networkSetting
+
+	if viper.IsSet("ssid") {
+		ssid := viper.GetString("ssid")
+		if ssid == "" || len(ssid) > 32 || strings.Contains(ssid, ";") {
+			return nil, fmt.Errorf("ssid \"%s\" is invalid, it must be 1 to 32 characters without a ;", ssid)
+		}
+		settings = append(settings, networkSetting{"SSId1", ssid})
+	}
+
+	if hasPassword {
+		value, err := secretFrom(map[string]interface{}{"password": password}, "password").Value()
+		if err != nil {
+			return nil, err
+		}
+		if len(value) < 8 || len(value) > 64 || strings.Contains(value, ";") {
+			return nil, errors.New("password is invalid, it must be 8 to 64 characters without a ;")
+		}
+		settings = append(settings, networkSetting{"Password1", value})
+	}
+
+	for i, flag := range []string{"ip", "gateway", "netmask", "dns"} {
+		if !viper.IsSet(flag) {
+			continue
+		}
+		value := viper.GetString(flag)
+		if flag == "ip" && strings.EqualFold(value, "dhcp") {
+			// tasmota uses dhcp when its address is 0.0.0.0
+			value = "0.0.0.0"
+		} else if ip := net.ParseIP(value); ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("--%s \"%s\" is not an ipv4 address", flag, value)
+		}
+		settings = append(settings, networkSetting{fmt.Sprintf("IPAddress%d", i+1), value})
+	}
+
+	if len(settings) == 0 {
+		return nil, errors.New("nothing to set, use --ssid, --password, --ip, --gateway, --netmask or --dns")
+	}
+	return settings, nil
+}
+
+// send network settings to devices, updating the configuration with a new ip address
+func runNetworkSet(devices []Device, group bool, settings []networkSetting) error {
+	var names []string
+	credentials, address := false, ""
+	for _, s := range settings {
+		names = append(names, s.command)
+		switch s.command {
+		case "SSId1", "Password1":
+			credentials = true
+		case "IPAddress1":
+			address = s.value
+		}
+	}
+
+	if address != "" && (group || len(devices) > 1) {
+		return errors.New("--ip can only be given to a single device, devices can't share an address")
+	}
+	if credentials && (group || len(devices) > 1) && !viper.GetBool("force") {
+		return fmt.Errorf("changing the wifi network or password of %d devices at once could take them all off the network, use --force if you are sure", len(devices))
+	}
+
+	var commands []string
+	for _, s := range settings {
+		commands = append(commands, s.command+" "+s.value)
+	}
+	backlog := "Backlog " + strings.Join(commands, "; ")
+
+	var failed error
+	failures := 0
+	for _, dev := range devices {
+		if _, err := sendTasmota(dev, url.QueryEscape(backlog)); err != nil {
+			if !group {
+				return err
+			}
+			// carry on with the rest of the group
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			if failed == nil {
+				failed = err
+			}
+			failures++
+			continue
+		}
+		fmt.Printf("%s: set %s, restarting\n", dev.Name, strings.Join(names, ", "))
+
+		if address != "" {
+			updateDeviceAddress(dev, address)
+		}
+	}
+
+	if failed != nil {
+		return &GroupError{Failures: failures, Total: len(devices), Err: failed}
+	}
+	return nil
+}
+
+// point a configured device at its new address, keeping any port, the device has already been
+// changed so problems are warnings
+func updateDeviceAddress(dev Device, address string) {
+	if address == "0.0.0.0" {
+		fmt.Fprintf(os.Stderr, "Warning: %s will get a new address from dhcp, update it with config set once it is known\n", dev.Name)
+		return
+	}
+	if !isConfigured(dev.Name) {
+		return
+	}
+
+	host := address
+	if _, port, err := net.SplitHostPort(dev.Host); err == nil {
+		host = net.JoinHostPort(address, port)
+	}
+
+	err := editConfig(func(c *configFile) error {
+		return setDeviceHost(c, dev.Name, host)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not change the address of %s in the configuration: %s\n", dev.Name, err)
+		return
+	}
+	fmt.Printf("%s: address changed to %s in the configuration\n", dev.Name, host)
+}
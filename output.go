@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// supported values of --output
+var outputFormats = []string{"table", "json", "yaml", "csv", "raw"}
+
+// a result that can be rendered in any output format
+type output struct {
+	Data    interface{}  // rendered by json and yaml, field names come from the json tags
+	Raw     []byte       // unmodified response from the device, rendered by raw
+	Columns []string     // header rendered by table and csv
+	Rows    [][]string   // rows rendered by table, csv and raw
+	Text    string       // output used when --output isn't set, defaults to table
+	Plugin  pluginResult // rendered by --nagios
+
+	// decoded response of the device, or of each device in a group by name, which --format
+	// and --select work on instead of Data when it is set
+	Response interface{}
+}
+
+// a single timer, same layout as each timer in AllTimers
+type Timer struct {
+	Enable int    `json:"Enable"`
+	Mode   int    `json:"Mode"`
+	Time   string `json:"Time"`
+	Window int    `json:"Window"`
+	Days   string `json:"Days"`
+	Repeat int    `json:"Repeat"`
+	Output int    `json:"Output"`
+	Action int    `json:"Action"`
+}
+
+// power state of a device
+type PowerResult struct {
+	Device string `json:"Device"`
+	Power  string `json:"Power"`
+}
+
+// a configured device
+type DeviceResult struct {
+	Profile string `json:"Profile,omitempty"`
+	Name    string `json:"Name"`
+	Address string `json:"Address"`
+}
+
+// checks if an output format is valid
+func isOutputValid(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// the output format chosen on the command line, empty if none was
+func outputFormat() string {
+	if viper.IsSet("output") {
+		return viper.GetString("output")
+	}
+	if viper.GetBool("json") {
+		return "json"
+	}
+	return ""
+}
+
+// render output in the format chosen by --output or --format, after any --select
+func render(w io.Writer, o output) error {
+	if viper.GetBool("nagios") {
+		return renderNagios(w, o)
+	}
+
+	if viper.IsSet("select") {
+		selected, err := selectOutput(o, viper.GetString("select"))
+		if err != nil {
+			return err
+		}
+		o = selected
+	}
+
+	if viper.IsSet("format") {
+		return renderTemplate(w, viper.GetString("format"), o)
+	}
+
+	switch outputFormat() {
+	case "json":
+		s, err := json.MarshalIndent(o.Data, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(s))
+		return err
+
+	case "yaml":
+		node, err := jsonNode(o.Data)
+		if err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(node); err != nil {
+			return err
+		}
+		return enc.Close()
+
+	case "csv":
+		c := csv.NewWriter(w)
+		if err := c.Write(o.Columns); err != nil {
+			return err
+		}
+		if err := c.WriteAll(o.Rows); err != nil {
+			return err
+		}
+		return c.Error()
+
+	case "raw":
+		if o.Raw != nil {
+			_, err := fmt.Fprintln(w, strings.TrimSpace(string(o.Raw)))
+			return err
+		}
+		for _, row := range o.Rows {
+			if _, err := fmt.Fprintln(w, strings.Join(row, " ")); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "table":
+		return renderTable(w, o.Columns, o.Rows)
+	}
+
+	// no output format chosen, so use the default for the command
+	if o.Text != "" {
+		_, err := io.WriteString(w, o.Text)
+		return err
+	}
+	return renderTable(w, o.Columns, o.Rows)
+}
+
+// print rows in a table with a header
+func renderTable(w io.Writer, columns []string, rows [][]string) error {
+	tw := new(tabwriter.Writer)
+
+	const padding = 1
+	tw.Init(w, 0, 2, padding, ' ', 0)
+
+	underline := make([]string, len(columns))
+	for i, c := range columns {
+		underline[i] = strings.Repeat("-", len(c))
+	}
+
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	fmt.Fprintln(tw, strings.Join(underline, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// convert data to a yaml node by way of json, keeping the json field names and ordering
+func jsonNode(data interface{}) (*yaml.Node, error) {
+	s, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(s, &node); err != nil {
+		return nil, err
+	}
+	resetStyle(&node)
+
+	return &node, nil
+}
+
+// clear the json flow style from a yaml node so it is output as block style yaml
+func resetStyle(node *yaml.Node) {
+	if node.Kind != yaml.ScalarNode {
+		node.Style = 0
+	} else if node.Style == yaml.DoubleQuotedStyle {
+		node.Style = 0
+	}
+	for _, n := range node.Content {
+		resetStyle(n)
+	}
+}
+
+// the data --format and --select work on, the response of the device when there is one
+func (o output) source() (interface{}, error) {
+	if o.Response != nil {
+		return genericData(o.Response)
+	}
+	return genericData(o.Data)
+}
+
+// convert data to maps and slices by way of json, keeping the json field names and exact numbers
+func genericData(data interface{}) (interface{}, error) {
+	s, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(s))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	return generic, nil
+}
+
+// flatten data into field and value rows, with nested fields joined by dots
+func flatten(data interface{}) ([][]string, error) {
+	node, err := jsonNode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	var walk func(n *yaml.Node, path string)
+	walk = func(n *yaml.Node, path string) {
+		switch n.Kind {
+		case yaml.DocumentNode:
+			for _, c := range n.Content {
+				walk(c, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				walk(n.Content[i+1], joinPath(path, n.Content[i].Value))
+			}
+		case yaml.SequenceNode:
+			for i, c := range n.Content {
+				walk(c, fmt.Sprintf("%s[%d]", path, i))
+			}
+		default:
+			rows = append(rows, []string{path, n.Value})
+		}
+	}
+	walk(node, "")
+
+	return rows, nil
+}
+
+// join a field name onto a dotted path
+func joinPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// output of power on, power off and status for one or more devices
+func powerOutput(results []PowerResult, raw []byte) output {
+	o := output{
+		Data:    results,
+		Raw:     raw,
+		Columns: []string{"Device", "Power"},
+	}
+
+	var text strings.Builder
+	var states []string
+	for _, r := range results {
+		o.Rows = append(o.Rows, []string{r.Device, r.Power})
+		fmt.Fprintf(&text, "%s:%s\n", r.Device, r.Power)
+		states = append(states, r.Device+" is "+r.Power)
+		if r.Power == "ERROR" {
+			o.Plugin.State = healthCrit
+		}
+	}
+	o.Text = text.String()
+	o.Plugin.Message = strings.Join(states, ", ")
+
+	// a single device is shown as an object rather than a list
+	if len(results) == 1 {
+		o.Data = results[0]
+	}
+
+	return o
+}
+
+// output of a response in a known or unknown format, shown as field and value rows
+func fieldsOutput(data interface{}, raw []byte) (output, error) {
+	rows, err := flatten(data)
+	if err != nil {
+		return output{}, err
+	}
+
+	s, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return output{}, err
+	}
+
+	return output{
+		Data:    data,
+		Raw:     raw,
+		Columns: []string{"Field", "Value"},
+		Rows:    rows,
+		Text:    string(s) + "\n",
+	}, nil
+}
+
+// output of a response to a custom command
+func customOutput(raw []byte) (output, error) {
+	if !json.Valid(raw) {
+		// not json, so the best we can do is show it as is
+		return output{
+			Data:    string(raw),
+			Raw:     raw,
+			Columns: []string{"Response"},
+			Rows:    [][]string{{strings.TrimSpace(string(raw))}},
+			Text:    string(raw) + "\n",
+		}, nil
+	}
+
+	// keep the ordering of the response rather than decoding it into a map
+	return fieldsOutput(json.RawMessage(raw), raw)
+}
+
+// output of all timers
+func timersOutput(res AllTimers, raw []byte) output {
+	timers := []Timer{
+		Timer(res.Timer1), Timer(res.Timer2), Timer(res.Timer3), Timer(res.Timer4),
+		Timer(res.Timer5), Timer(res.Timer6), Timer(res.Timer7), Timer(res.Timer8),
+		Timer(res.Timer9), Timer(res.Timer10), Timer(res.Timer11), Timer(res.Timer12),
+		Timer(res.Timer13), Timer(res.Timer14), Timer(res.Timer15), Timer(res.Timer16),
+	}
+
+	o := output{
+		Data:    res,
+		Raw:     raw,
+		Columns: []string{"Name", "Enabled", "Mode", "Time", "Window", "Days", "Repeat", "Output", "Action"},
+	}
+	for i, t := range timers {
+		o.Rows = append(o.Rows, []string{
+			fmt.Sprintf("Timer%d", i+1),
+			fmt.Sprint(t.Enable), fmt.Sprint(t.Mode), t.Time, fmt.Sprint(t.Window),
+			t.Days, fmt.Sprint(t.Repeat), fmt.Sprint(t.Output), fmt.Sprint(t.Action),
+		})
+	}
+
+	var text bytes.Buffer
+	renderTable(&text, o.Columns, o.Rows)
+	text.WriteString("\nFurther details available here: https://tasmota.github.io/docs/Timers/#json-payload-anatomy\n\n")
+	o.Text = text.String()
+
+	return o
+}
+
+// output of health checks, as a nagios plugin the message is the checks that aren't ok
+func healthOutput(results []HealthResult) output {
+	o := output{
+		Data:    results,
+		Columns: []string{"Device", "Check", "State", "Message"},
+	}
+
+	// label performance data with the device when there is more than one
+	devices := map[string]bool{}
+	for _, r := range results {
+		devices[r.Device] = true
+	}
+
+	var problems []string
+	for _, r := range results {
+		o.Rows = append(o.Rows, []string{r.Device, r.Check, r.State, r.Message})
+
+		o.Plugin.State = worseState(o.Plugin.State, r.state)
+		if r.state != healthOK {
+			problems = append(problems, fmt.Sprintf("%s %s: %s", r.Device, r.Check, r.Message))
+		}
+
+		label := r.Check
+		if len(devices) > 1 {
+			label = r.Device + "." + r.Check
+		}
+		if r.Check != "status" {
+			o.Plugin.Perf = append(o.Plugin.Perf, perfData{label: label, value: r.Value, warn: r.warnRange(r.Warn), crit: r.warnRange(r.Crit)})
+		}
+	}
+
+	o.Plugin.Message = strings.Join(problems, ", ")
+	if len(problems) == 0 {
+		o.Plugin.Message = fmt.Sprintf("all %d checks are ok", len(results))
+	}
+	return o
+}
+
+// output of the configured devices
+func devicesOutput() output {
+	devices := []DeviceResult{}
+	var rows [][]string
+	for _, name := range deviceNames() {
+		dev, err := getDevice(name)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, DeviceResult{Name: name, Address: dev.Host})
+		rows = append(rows, []string{dev.Host, name})
+	}
+
+	return output{
+		Data:    devices,
+		Columns: []string{"IP", "Name"},
+		Rows:    rows,
+	}
+}
+
+// output of the devices of the top level and every profile
+func profileDevicesOutput(devices []DeviceResult) output {
+	var rows [][]string
+	for _, d := range devices {
+		rows = append(rows, []string{d.Profile, d.Address, d.Name})
+	}
+
+	return output{
+		Data:    devices,
+		Columns: []string{"Profile", "IP", "Name"},
+		Rows:    rows,
+	}
+}
+
+// output of the configuration
+func configOutput() output {
+	settings := maskSecrets(viper.AllSettings())
+
+	o := output{
+		Data:    settings,
+		Columns: []string{"Config", "Setting"},
+	}
+	for _, k := range sortedKeys(settings) {
+		o.Rows = append(o.Rows, []string{k, fmt.Sprintf("%v", settings[k])})
+	}
+
+	return o
+}
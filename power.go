@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// structure of responses to status 11
+type StateResponse struct {
+	StatusSTS map[string]interface{} `json:"StatusSTS"`
+}
+
+// run on, off or status against one or more devices
+func runPower(devices []Device, command string, group bool) error {
+	relay := viper.GetInt("relay")
+
+	var results []PowerResult
+	var raw []byte
+	responses := map[string]json.RawMessage{}
+	var failed error
+	failures := 0
+
+	for _, dev := range devices {
+		var power string
+		var response []byte
+		var err error
+
+		if command == "status" {
+			power, response, err = readStatusPower(dev, relay)
+		} else {
+			power, response, err = switchPower(dev, relay, command)
+		}
+
+		if err != nil {
+			if !group {
+				return err
+			}
+			// carry on with the rest of the group
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			if failed == nil {
+				failed = err
+			}
+			failures++
+			power = "ERROR"
+		}
+
+		results = append(results, PowerResult{Device: dev.Name, Power: power})
+		raw = response
+		if err == nil && json.Valid(response) {
+			responses[dev.Name] = response
+		}
+	}
+
+	o := powerOutput(results, raw)
+	switch {
+	case group:
+		o.Raw = nil
+		o.Response = responses
+	case command == "status":
+		o.Text = results[0].Power + "\n"
+	}
+	if !group && json.Valid(raw) {
+		o.Response = json.RawMessage(raw)
+	}
+
+	if err := render(os.Stdout, o); err != nil {
+		return err
+	}
+
+	if failed != nil {
+		return &GroupError{Failures: failures, Total: len(devices), Err: failed}
+	}
+	return nil
+}
+
+// switch a relay on or off, checking the device reached the state if --verify is set
+func switchPower(dev Device, relay int, state string) (string, []byte, error) {
+	wanted := strings.ToUpper(state)
+	cmd := powerCommand(relay) + strings.TrimPrefix(commandList[state], "Power")
+
+	attempts := 1
+	if viper.GetBool("verify") && viper.GetInt("verify-attempts") > 1 {
+		attempts = viper.GetInt("verify-attempts")
+	}
+
+	for attempt := 1; ; attempt++ {
+		response, err := sendTasmota(dev, cmd)
+		if err != nil {
+			return "", nil, err
+		}
+
+		fields := map[string]interface{}{}
+		if err := decodeResponse(dev.Host, response, &fields); err != nil {
+			return "", response, err
+		}
+		power, _ := findPower(fields, relay)
+
+		if !viper.GetBool("verify") {
+			return power, response, nil
+		}
+
+		// give the relay time to switch, then read back what the device thinks the state is
+		time.Sleep(globalDuration("verify-delay"))
+
+		actual, err := readPower(dev, relay)
+		if err == nil && actual == wanted {
+			return actual, response, nil
+		}
+
+		if err != nil {
+			actual = err.Error()
+		}
+		if verbose {
+			fmt.Printf("Verify %d/%d for %s: wanted %s, got %s\n", attempt, attempts, dev.Name, wanted, actual)
+		}
+
+		if attempt >= attempts {
+			return actual, response, &DeviceError{
+				Kind:    ErrNotVerified,
+				Address: dev.Host,
+				Err:     fmt.Errorf("wanted %s, got %s after %d attempts", wanted, actual, attempts),
+			}
+		}
+	}
+}
+
+// read the power state of a relay using status 11
+func readPower(dev Device, relay int) (string, error) {
+	response, err := sendTasmota(dev, "Status%2011")
+	if err != nil {
+		return "", err
+	}
+
+	res := StateResponse{}
+	if err := decodeResponse(dev.Host, response, &res); err != nil {
+		return "", err
+	}
+
+	power, ok := findPower(res.StatusSTS, relay)
+	if !ok {
+		return "", &DeviceError{Kind: ErrInvalidJSON, Address: dev.Host, Err: fmt.Errorf("no power state for relay %d", relay)}
+	}
+	return power, nil
+}
+
+// read the power state of a relay from status 0, as --cmd status has always done
+func readStatusPower(dev Device, relay int) (string, []byte, error) {
+	response, err := sendTasmota(dev, commandList["status"])
+	if err != nil {
+		return "", nil, err
+	}
+
+	res := StatusResponse{}
+	if err := decodeResponse(dev.Host, response, &res); err != nil {
+		return "", response, err
+	}
+
+	// power is a bitmask of all relays
+	if relay > 0 {
+		if res.Status.Power&(1<<(relay-1)) != 0 {
+			return "ON", response, nil
+		}
+		return "OFF", response, nil
+	}
+
+	var powerState string
+	switch res.Status.Power {
+	case 0:
+		powerState = "OFF"
+	case 1:
+		powerState = "ON"
+	default:
+		powerState = "UNKNOWN"
+	}
+	return powerState, response, nil
+}
+
+// tasmota command for a relay, relay 0 is the default relay
+func powerCommand(relay int) string {
+	if relay > 0 {
+		return fmt.Sprintf("Power%d", relay)
+	}
+	return "Power"
+}
+
+// find the state of a relay in a response, devices with one relay use POWER, others POWER1, POWER2, etc
+func findPower(fields map[string]interface{}, relay int) (string, bool) {
+	keys := []string{"POWER", "POWER1"}
+	if relay > 0 {
+		keys = []string{fmt.Sprintf("POWER%d", relay)}
+		if relay == 1 {
+			keys = append(keys, "POWER")
+		}
+	}
+
+	for _, k := range keys {
+		if power, ok := fields[k].(string); ok {
+			return power, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// the profile chosen by --profile or TASCLI_PROFILE, if any
+func profileName() string {
+	return strings.ToLower(viper.GetString("profile"))
+}
+
+// sorted list of configured profile names
+func profileNames() []string {
+	return sortedKeys(viper.GetStringMap("profiles"))
+}
+
+// apply the chosen profile, a profile is a set of settings that replace the top level settings:
+//
+//	timeout: 5s
+//	profiles:
+//	  office:
+//	    timeout: 10s
+//	    password_cmd: pass show tasmota/office
+//	    devices:
+//	      printer: 10.1.0.20
+//
+// settings a profile doesn't have, such as timeout, retries or user, come from the top level
+func applyProfile() error {
+	name := profileName()
+	if name == "" {
+		return nil
+	}
+
+	settings, err := fileSettings()
+	if err != nil {
+		return err
+	}
+
+	profiles, _ := settings["profiles"].(map[string]interface{})
+	profile, ok := findProfile(profiles, name)
+	if !ok {
+		if len(profiles) == 0 {
+			return fmt.Errorf("profile %s not found, no profiles are configured", name)
+		}
+		return fmt.Errorf("profile %s not found, must be one of: %s", name, strings.Join(sortedKeys(profiles), ", "))
+	}
+
+	overrides, err := cast.ToStringMapE(profile)
+	if err != nil {
+		return fmt.Errorf("profile %s must be a map of settings", name)
+	}
+	for k, v := range overrides {
+		settings[strings.ToLower(k)] = v
+	}
+
+	// replace the settings viper read from the file, leaving flags and environment variables in charge
+	b, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Printf("Profile: %s\n", name)
+	}
+	return viper.ReadConfig(bytes.NewReader(b))
+}
+
+// a profile by its name, ignoring case as viper does for the rest of the configuration
+func findProfile(profiles map[string]interface{}, name string) (interface{}, bool) {
+	for k, v := range profiles {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// the settings in the configuration file, with lower case keys as viper has them
+func fileSettings() (map[string]interface{}, error) {
+	settings := map[string]interface{}{}
+	if viper.ConfigFileUsed() == "" {
+		return settings, nil
+	}
+
+	b, err := os.ReadFile(viper.ConfigFileUsed())
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, &settings); err != nil {
+		return nil, err
+	}
+
+	lower := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		lower[strings.ToLower(k)] = v
+	}
+	return lower, nil
+}
+
+// devices of the top level and of every profile, the top level has an empty profile name
+func allProfileDevices() ([]DeviceResult, error) {
+	settings, err := fileSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := map[string]map[string]interface{}{"": settings}
+	names := []string{""}
+	profiles, _ := settings["profiles"].(map[string]interface{})
+	for _, name := range sortedKeys(profiles) {
+		profile, _ := profiles[name].(map[string]interface{})
+		scopes[name] = profile
+		names = append(names, name)
+	}
+
+	devices := []DeviceResult{}
+	for _, profile := range names {
+		entries, _ := scopes[profile]["devices"].(map[string]interface{})
+		for _, name := range sortedKeys(entries) {
+			devices = append(devices, DeviceResult{Profile: profile, Name: name, Address: entryHost(entries[name])})
+		}
+	}
+	return devices, nil
+}
+
+// the address of a device entry, which is either the address or a map with a host
+func entryHost(entry interface{}) string {
+	if m, ok := entry.(map[string]interface{}); ok {
+		return cast.ToString(m["host"])
+	}
+	return cast.ToString(entry)
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// largest network that is scanned, a /20 of 4096 addresses
+const maxScanBits = 12
+
+var resolveCmd = &cobra.Command{
+	Use:   "resolve [device|group]",
+	Short: "Find devices that have moved to a new address by their mac address",
+	Long: `Check a device or group, or all configured devices, are still at their configured address,
+finding any that have moved, e.g. when dhcp has given them a new address.
+
+Each device's mac address is recorded in the configuration the first time it is seen. A device
+that no longer answers, or answers with a different mac address, is looked for at the address it
+was last seen at and then on the networks in the subnets setting or --subnets. When it is found
+its address in the configuration is changed.
+
+Subnets are networks such as 192.168.1.0/24, up to a /20, or single addresses with an optional
+port. Devices are found using the top level user and password.
+
+Exits with 1 if any devices can't be found.`,
+	Example: `  tasmota-cli resolve
+  tasmota-cli resolve lamp --subnets 192.168.1.0/24,192.168.2.0/24`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		devices, _, err := resolveTargetsOrAll(args)
+		if err != nil {
+			return err
+		}
+		for _, dev := range devices {
+			if !isConfigured(dev.Name) {
+				return fmt.Errorf("%s isn't a configured device, only configured devices can be resolved", dev.Name)
+			}
+		}
+		return runResolve(devices)
+	},
+}
+
+func init() {
+	resolveCmd.Flags().StringSlice("subnets", nil, "Networks to search for devices, e.g. 192.168.1.0/24")
+	resolveCmd.Flags().Duration("scan-timeout", time.Second, "Time to wait for each address while searching")
+
+	rootCmd.AddCommand(resolveCmd)
+}
+
+// where a device was found
+type ResolveResult struct {
+	Device  string `json:"Device"`
+	Address string `json:"Address"`
+	Mac     string `json:"Mac"`
+	Result  string `json:"Result"`
+
+	moved     bool // the address has changed
+	recordMac bool // the mac address isn't in the configuration yet
+	lost      bool // the device couldn't be found
+}
+
+// check devices are where they are configured, searching for those that have moved and
+// updating the configuration and cache, returning an error if any can't be found
+func runResolve(devices []Device) error {
+	cache, err := loadCache()
+	if err != nil {
+		return err
+	}
+
+	macs := make([]string, len(devices))
+	errs := queryDevices(devices, func(i int, dev Device) error {
+		var err error
+		macs[i], err = readMac(dev)
+		return err
+	})
+
+	results := checkAddresses(devices, macs, errs, cache)
+	if err := findMoved(devices, results, cache); err != nil {
+		return err
+	}
+
+	if err := updateConfig(results); err != nil {
+		return fmt.Errorf("could not update the configuration: %s", err)
+	}
+
+	now := time.Now()
+	lost := 0
+	for _, r := range results {
+		if r.lost {
+			lost++
+			continue
+		}
+		cache.Devices[cacheKey(r.Device)] = cachedDevice{Mac: r.Mac, Address: r.Address, Seen: now}
+	}
+	if err := cache.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not save %s: %s\n", cache.path, err)
+	}
+
+	if err := render(os.Stdout, resolveOutput(results)); err != nil {
+		return err
+	}
+	if lost > 0 {
+		// the devices that weren't found have been printed, so only the exit code is left
+		return &CheckError{State: healthWarn}
+	}
+	return nil
+}
+
+// compare the mac address each device answered with to the one it should have, from the
+// configuration or else the cache, marking devices that need to be looked for as lost
+func checkAddresses(devices []Device, macs []string, errs []error, cache *deviceCache) []ResolveResult {
+	results := make([]ResolveResult, len(devices))
+	for i, dev := range devices {
+		r := &results[i]
+		r.Device, r.Address, r.Mac = dev.Name, dev.Host, dev.Mac
+		if r.Mac == "" {
+			r.Mac = cache.Devices[cacheKey(dev.Name)].Mac
+		}
+
+		switch {
+		case errs[i] == nil && (r.Mac == "" || sameMac(r.Mac, macs[i])):
+			r.Mac, r.Result = macs[i], "ok"
+			if dev.Mac == "" {
+				r.recordMac, r.Result = true, "mac address recorded"
+			}
+		case r.Mac == "":
+			r.lost, r.Result = true, fmt.Sprintf("can't be reached and has no known mac address to find it by: %s", errs[i])
+		case errs[i] != nil:
+			r.lost, r.Result = true, fmt.Sprintf("can't be reached: %s", errs[i])
+		default:
+			r.lost, r.Result = true, fmt.Sprintf("%s is now %s", dev.Host, macs[i])
+		}
+	}
+	return results
+}
+
+// look for lost devices where they were last seen and then on the subnets
+func findMoved(devices []Device, results []ResolveResult, cache *deviceCache) error {
+	// the address each lost device was last seen at, if it isn't where it's configured
+	var candidates []Device
+	var waiting []int
+	for i, dev := range devices {
+		r := results[i]
+		if !r.lost || r.Mac == "" {
+			continue
+		}
+		if seen, ok := cache.Devices[cacheKey(dev.Name)]; ok && seen.Address != "" && seen.Address != dev.Host {
+			dev.Host = seen.Address
+			candidates = append(candidates, dev)
+			waiting = append(waiting, i)
+		}
+	}
+	macs := make([]string, len(candidates))
+	queryDevices(candidates, func(i int, dev Device) error {
+		var err error
+		macs[i], err = readMac(dev)
+		return err
+	})
+	for j, i := range waiting {
+		if sameMac(results[i].Mac, macs[j]) {
+			results[i].found(devices[i], candidates[j].Host)
+		}
+	}
+
+	searching := false
+	for _, r := range results {
+		searching = searching || (r.lost && r.Mac != "")
+	}
+	if !searching {
+		return nil
+	}
+
+	subnets := viper.GetStringSlice("subnets")
+	if len(subnets) == 0 {
+		for i := range results {
+			if results[i].lost && results[i].Mac != "" {
+				results[i].Result += ", set subnets to search for it"
+			}
+		}
+		return nil
+	}
+
+	found, err := scanSubnets(subnets, globalDuration("scan-timeout"))
+	if err != nil {
+		return err
+	}
+	for i := range results {
+		r := &results[i]
+		if !r.lost || r.Mac == "" {
+			continue
+		}
+		if address, ok := found[normalMac(r.Mac)]; ok {
+			r.found(devices[i], address)
+		} else {
+			r.Result += ", not found on " + strings.Join(subnets, ", ")
+		}
+	}
+	return nil
+}
+
+// record new addresses and mac addresses in the configuration, leaving it alone if nothing changed
+func updateConfig(results []ResolveResult) error {
+	changed := false
+	for _, r := range results {
+		changed = changed || r.moved || r.recordMac
+	}
+	if !changed {
+		return nil
+	}
+
+	return editConfig(func(c *configFile) error {
+		for _, r := range results {
+			if r.moved {
+				if err := setDeviceHost(c, r.Device, r.Address); err != nil {
+					return err
+				}
+			}
+			if r.recordMac {
+				if err := setDeviceSetting(c, r.Device, "mac", r.Mac); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// a lost device has been found at a new address
+func (r *ResolveResult) found(dev Device, address string) {
+	r.Address, r.Result = address, "moved from "+dev.Host
+	r.lost, r.moved, r.recordMac = false, true, dev.Mac == ""
+}
+
+// read the mac address of a device from its network status
+func readMac(dev Device) (string, error) {
+	response, err := sendTasmota(dev, "Status%205")
+	if err != nil {
+		return "", err
+	}
+
+	res := StatusResponse{}
+	if err := decodeResponse(dev.Host, response, &res); err != nil {
+		return "", err
+	}
+	if res.StatusNET.Mac == "" {
+		return "", fmt.Errorf("%s didn't give its mac address", dev.Host)
+	}
+	return res.StatusNET.Mac, nil
+}
+
+// mac addresses are the same, whatever their case or separators
+func sameMac(a, b string) bool {
+	return a != "" && normalMac(a) == normalMac(b)
+}
+
+// a mac address written as DC:4F:22:12:34:56
+func normalMac(mac string) string {
+	if hw, err := net.ParseMAC(mac); err == nil {
+		return strings.ToUpper(hw.String())
+	}
+	return strings.ToUpper(mac)
+}
+
+// search subnets for devices, returning the address of each mac address found
+func scanSubnets(subnets []string, timeout time.Duration) (map[string]string, error) {
+	addresses, err := scanAddresses(subnets)
+	if err != nil {
+		return nil, err
+	}
+
+	probes := make([]Device, len(addresses))
+	for i, address := range addresses {
+		probes[i] = hostDevice(address)
+		probes[i].Timeout, probes[i].Retries = timeout, 0
+	}
+	macs := make([]string, len(probes))
+	queryDevices(probes, func(i int, dev Device) error {
+		var err error
+		macs[i], err = readMac(dev)
+		return err
+	})
+
+	found := map[string]string{}
+	for i, mac := range macs {
+		if mac != "" {
+			found[normalMac(mac)] = addresses[i]
+		}
+	}
+	return found, nil
+}
+
+// the addresses of networks such as 192.168.1.0/24, without their network and broadcast
+// addresses, and single addresses with an optional port
+func scanAddresses(subnets []string) ([]string, error) {
+	var addresses []string
+	for _, subnet := range subnets {
+		if !strings.Contains(subnet, "/") {
+			if err := checkHost(subnet); err != nil {
+				return nil, fmt.Errorf("\"%s\" is not a network such as 192.168.1.0/24 or an address", subnet)
+			}
+			addresses = append(addresses, subnet)
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(subnet)
+		if err != nil || network.IP.To4() == nil {
+			return nil, fmt.Errorf("\"%s\" is not an ipv4 network such as 192.168.1.0/24", subnet)
+		}
+		ones, bits := network.Mask.Size()
+		if bits-ones > maxScanBits {
+			return nil, fmt.Errorf("%s is too large to search, it can be up to a /%d", subnet, bits-maxScanBits)
+		}
+
+		first := uint64(binary.BigEndian.Uint32(network.IP.To4()))
+		last := first + 1<<(bits-ones) - 1
+		if bits-ones > 1 {
+			first, last = first+1, last-1
+		}
+		for n := first; n <= last; n++ {
+			ip := make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, uint32(n))
+			addresses = append(addresses, ip.String())
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, errors.New("no subnets to search")
+	}
+	return addresses, nil
+}
+
+// output of where devices were found
+func resolveOutput(results []ResolveResult) output {
+	o := output{
+		Data:    results,
+		Columns: []string{"Device", "Address", "Mac", "Result"},
+	}
+	for _, r := range results {
+		o.Rows = append(o.Rows, []string{r.Device, r.Address, r.Mac, r.Result})
+	}
+	return o
+}
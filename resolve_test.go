@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanAddresses(t *testing.T) {
+	tests := []struct {
+		subnets []string
+		count   int
+		first   string
+		last    string
+		err     string
+	}{
+		{subnets: []string{"192.168.1.0/24"}, count: 254, first: "192.168.1.1", last: "192.168.1.254"},
+		{subnets: []string{"192.168.1.77/24"}, count: 254, first: "192.168.1.1", last: "192.168.1.254"},
+		{subnets: []string{"10.0.0.0/20"}, count: 4094, first: "10.0.0.1", last: "10.0.15.254"},
+		{subnets: []string{"10.0.0.4/31"}, count: 2, first: "10.0.0.4", last: "10.0.0.5"},
+		{subnets: []string{"10.0.0.4/32", "lamp.home", "127.0.0.1:8080"}, count: 3, first: "10.0.0.4", last: "127.0.0.1:8080"},
+		{subnets: []string{"10.0.0.0/19"}, err: "too large"},
+		{subnets: []string{"fe80::/120"}, err: "not an ipv4 network"},
+		{subnets: []string{"10.0.0.0/33"}, err: "not an ipv4 network"},
+		{subnets: []string{"bad host"}, err: "not a network"},
+		{subnets: nil, err: "no subnets"},
+	}
+	for _, tt := range tests {
+		got, err := scanAddresses(tt.subnets)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("scanAddresses(%v) error = %v, want %q", tt.subnets, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("scanAddresses(%v): %s", tt.subnets, err)
+			continue
+		}
+		if len(got) != tt.count || got[0] != tt.first || got[len(got)-1] != tt.last {
+			t.Errorf("scanAddresses(%v) = %d addresses from %s to %s, want %d from %s to %s",
+				tt.subnets, len(got), got[0], got[len(got)-1], tt.count, tt.first, tt.last)
+		}
+	}
+}
+
+func TestCheckAddresses(t *testing.T) {
+	devices := []Device{
+		{Name: "lamp", Host: "10.0.0.1", Mac: "DC:4F:22:00:00:01"},
+		{Name: "strip", Host: "10.0.0.2", Mac: "dc-4f-22-00-00-02"},
+		{Name: "fan", Host: "10.0.0.3"},
+		{Name: "heater", Host: "10.0.0.4"},
+		{Name: "pump", Host: "10.0.0.5"},
+		{Name: "gone", Host: "10.0.0.6"},
+	}
+	macs := []string{"DC:4F:22:00:00:01", "DC:4F:22:00:00:09", "DC:4F:22:00:00:03", "DC:4F:22:00:00:04", "", ""}
+	unreachable := errors.New("test error")
+	errs := []error{nil, nil, nil, nil, unreachable, unreachable}
+	cache := &deviceCache{Devices: map[string]cachedDevice{
+		"heater": {Mac: "DC:4F:22:00:00:14"},
+		"pump":   {Mac: "DC:4F:22:00:00:05"},
+	}}
+
+	want := []ResolveResult{
+		{Device: "lamp", Address: "10.0.0.1", Mac: "DC:4F:22:00:00:01", Result: "ok"},
+		{Device: "strip", Address: "10.0.0.2", Mac: "dc-4f-22-00-00-02", Result: "10.0.0.2 is now DC:4F:22:00:00:09", lost: true},
+		{Device: "fan", Address: "10.0.0.3", Mac: "DC:4F:22:00:00:03", Result: "mac address recorded", recordMac: true},
+		{Device: "heater", Address: "10.0.0.4", Mac: "DC:4F:22:00:00:14", Result: "10.0.0.4 is now DC:4F:22:00:00:04", lost: true},
+		{Device: "pump", Address: "10.0.0.5", Mac: "DC:4F:22:00:00:05", Result: "can't be reached: test error", lost: true},
+		{Device: "gone", Address: "10.0.0.6", Result: "can't be reached and has no known mac address to find it by: test error", lost: true},
+	}
+	got := checkAddresses(devices, macs, errs, cache)
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("checkAddresses %s = %+v, want %+v", devices[i].Name, got[i], want[i])
+		}
+	}
+}
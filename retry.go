@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// longest time to wait between retries
+const maxRetryDelay = 30 * time.Second
+
+// tasmota commands that only read state even when given a parameter, e.g. Status 0
+var readCommands = map[string]bool{
+	"status": true,
+	"state":  true,
+}
+
+// tasmota commands that return their current value when given without a parameter, e.g. Timers,
+// SSId1 or Power2 for the state of a relay, commands such as Restart act without one so aren't here
+var getterCommands = map[string]bool{
+	"power":        true,
+	"timers":       true,
+	"timer":        true,
+	"dimmer":       true,
+	"color":        true,
+	"ct":           true,
+	"hsbcolor":     true,
+	"module":       true,
+	"gpio":         true,
+	"template":     true,
+	"friendlyname": true,
+	"devicename":   true,
+	"hostname":     true,
+	"ssid":         true,
+	"ipaddress":    true,
+	"topic":        true,
+	"grouptopic":   true,
+	"fulltopic":    true,
+	"mqtthost":     true,
+	"mqttport":     true,
+	"mqttuser":     true,
+	"mqttclient":   true,
+	"teleperiod":   true,
+	"setoption":    true,
+	"timezone":     true,
+	"weblog":       true,
+	"seriallog":    true,
+	"syslog":       true,
+	"loghost":      true,
+	"logport":      true,
+}
+
+// checks if a command only reads state, so is safe to retry
+func isReadCommand(cmd string) bool {
+	unescaped, err := url.QueryUnescape(cmd)
+	if err != nil {
+		unescaped = cmd
+	}
+
+	fields := strings.Fields(unescaped)
+	if len(fields) == 0 {
+		return false
+	}
+
+	name := strings.ToLower(strings.TrimRight(fields[0], "0123456789"))
+	if readCommands[name] {
+		return true
+	}
+
+	return len(fields) == 1 && getterCommands[name]
+}
+
+// checks if an error is worth retrying
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrUnreachable) || errors.Is(err, ErrTimeout) {
+		return true
+	}
+
+	// server errors may go away, client errors won't
+	var deviceErr *DeviceError
+	return errors.As(err, &deviceErr) && errors.Is(err, ErrHTTP) && deviceErr.Status >= 500
+}
+
+// time to wait before a retry, doubling each attempt, with jitter to spread out retries
+func retryDelay(backoff time.Duration, attempt int) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+
+	delay := backoff << (attempt - 1)
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	// somewhere between half and all of the delay
+	if half := int64(delay / 2); half > 0 {
+		delay = delay/2 + time.Duration(rand.Int63n(half+1))
+	}
+	return delay
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestIsReadCommand(t *testing.T) {
+	tests := map[string]bool{
+		"Status0":              true,
+		"Status%2011":          true,
+		"Status 8":             true,
+		"state":                true,
+		"Timers":               true,
+		"SSId1":                true,
+		"WebLog":               true,
+		"IPAddress2":           true,
+		"SetOption19":          true,
+		"Timers 1":             false,
+		"WebLog 4":             false,
+		"Power":                true,
+		"Power1":               true,
+		"Power2 Toggle":        false,
+		"Power%20On":           false,
+		"Restart":              false,
+		"Restart 1":            false,
+		"Reset":                false,
+		"Upgrade":              false,
+		"WifiScan":             false,
+		"Backlog":              false,
+		"Backlog%20Status%200": false,
+		"Dimmer%3B%20Restart":  false,
+		"":                     false,
+	}
+	for cmd, want := range tests {
+		if got := isReadCommand(cmd); got != want {
+			t.Errorf("isReadCommand(%q) = %v, want %v", cmd, got, want)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// checks the value of a setting in the configuration file
+type settingCheck func(node *yaml.Node) error
+
+// settings allowed at the top level of the configuration file, devices and groups are checked on their own
+var globalSettings = map[string]settingCheck{
+	"verbose":         isBool,
+	"timeout":         isDuration,
+	"retries":         isCount,
+	"retry-backoff":   isDuration,
+	"retry-writes":    isBool,
+	"output":          isOneOf(outputFormats...),
+	"json":            isBool,
+	"format":          isString,
+	"select":          isString,
+	"verify":          isBool,
+	"verify-attempts": isCount,
+	"verify-delay":    isDuration,
+	"listen":          isString,
+	"logdir":          isString,
+	"level":           isCount,
+	"health":          isHealthThresholds,
+	"broker":          isString,
+	"cache":           isString,
+	"subnets":         isSubnets,
+	"scan-timeout":    isDuration,
+	"user":            isString,
+	"password":        isString,
+	"password_cmd":    isString,
+	"password_file":   isString,
+}
+
+// settings allowed for a device given as a map
+var deviceSettings = map[string]settingCheck{
+	"host":          isHost,
+	"timeout":       isDuration,
+	"retries":       isCount,
+	"retry-backoff": isDuration,
+	"tags":          isStringList,
+	"mac":           isMac,
+	"user":          isString,
+	"password":      isString,
+	"password_cmd":  isString,
+	"password_file": isString,
+}
+
+// the configuration file has problems
+type ConfigError struct {
+	Problems []configProblem
+}
+
+func (e *ConfigError) Error() string {
+	lines := []string{fmt.Sprintf("configuration has %s:", countProblems(len(e.Problems)))}
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// a configuration file given by --config or TASCLI_CONFIG doesn't exist
+type ConfigNotFoundError struct {
+	Path string
+}
+
+func (e *ConfigNotFoundError) Error() string {
+	return fmt.Sprintf("configuration file %s not found", e.Path)
+}
+
+// number of problems, e.g. 1 problem or 2 problems
+func countProblems(n int) string {
+	if n == 1 {
+		return "1 problem"
+	}
+	return fmt.Sprintf("%d problems", n)
+}
+
+// check the configuration file viper loaded, reporting every problem found
+func checkConfig() error {
+	if viper.ConfigFileUsed() == "" {
+		return nil
+	}
+
+	c, err := openConfigFile()
+	if err != nil {
+		return err
+	}
+
+	// unknown settings are only warnings, so a typo or a setting from a newer version doesn't stop every command
+	problems, warnings := splitWarnings(validateConfig(c))
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// separate the problems that are only warnings from the others
+func splitWarnings(all []configProblem) (problems, warnings []configProblem) {
+	for _, p := range all {
+		if p.warning {
+			warnings = append(warnings, p)
+		} else {
+			problems = append(problems, p)
+		}
+	}
+	return problems, warnings
+}
+
+// check the settings, devices, groups and profiles in the configuration file
+func validateConfig(c *configFile) []configProblem {
+	root := c.root()
+	if root.Kind != yaml.MappingNode {
+		return []configProblem{{path: c.path, line: root.Line, message: "configuration must be a map"}}
+	}
+
+	problems := validateScope(c, root, nil, "")
+
+	if key, profiles := findKey(root, "profiles"); profiles != nil {
+		if profiles.Kind != yaml.MappingNode {
+			return append(problems, configProblem{path: c.path, line: key.Line, message: "profiles must be a map of names to settings"})
+		}
+		seen := map[string]*yaml.Node{}
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			name, profile := profiles.Content[i], profiles.Content[i+1]
+
+			// profiles are chosen ignoring case, so Office and office are the same profile
+			lower := strings.ToLower(name.Value)
+			if first, ok := seen[lower]; ok {
+				problems = append(problems, configProblem{path: c.path, line: name.Line, message: fmt.Sprintf("profile %s is already defined on line %d", name.Value, first.Line)})
+			}
+			seen[lower] = name
+
+			if profile.Kind != yaml.MappingNode {
+				problems = append(problems, configProblem{path: c.path, line: profile.Line, message: fmt.Sprintf("profile %s must be a map of settings", name.Value)})
+				continue
+			}
+			problems = append(problems, validateScope(c, profile, root, "profile "+name.Value+": ")...)
+		}
+	}
+
+	return problems
+}
+
+// check the settings, devices and groups of the top level or a profile, groups in a profile
+// without its own devices use the top level devices
+func validateScope(c *configFile, scope, parent *yaml.Node, prefix string) []configProblem {
+	var problems []configProblem
+	problem := func(node *yaml.Node, format string, a ...interface{}) {
+		problems = append(problems, configProblem{path: c.path, line: node.Line, message: prefix + fmt.Sprintf(format, a...)})
+	}
+	warning := func(node *yaml.Node, format string, a ...interface{}) {
+		problem(node, format, a...)
+		problems[len(problems)-1].warning = true
+	}
+
+	// viper ignores the case of keys, so Timeout and timeout are the same setting
+	seen := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(scope.Content); i += 2 {
+		key, value := scope.Content[i], scope.Content[i+1]
+		name := strings.ToLower(key.Value)
+
+		if first, ok := seen[name]; ok {
+			problem(key, "%s is already set on line %d", key.Value, first.Line)
+		}
+		seen[name] = key
+
+		if name == "devices" || name == "groups" || (name == "profiles" && parent == nil) {
+			continue
+		}
+		check, ok := globalSettings[name]
+		if !ok {
+			warning(key, "unknown setting %s", key.Value)
+			continue
+		}
+		if err := check(value); err != nil {
+			problem(value, "%s %s", key.Value, err)
+		}
+	}
+	if sources := presentKeys(scope, secretKeys("password")); len(sources) > 1 {
+		problem(scope, "only one of %s can be set", strings.Join(sources, ", "))
+	}
+
+	devicesKey, devices := findKey(scope, "devices")
+	if devices == nil && parent != nil {
+		_, devices = findKey(parent, "devices")
+	}
+
+	names := map[string]bool{}
+	if devices != nil && devices.Kind != yaml.MappingNode {
+		if devicesKey != nil {
+			problem(devicesKey, "devices must be a map of names to addresses")
+		}
+	} else if devices != nil {
+		seen := map[string]*yaml.Node{}
+		for i := 0; i+1 < len(devices.Content); i += 2 {
+			name, value := devices.Content[i], devices.Content[i+1]
+
+			lower := strings.ToLower(name.Value)
+			names[lower] = true
+
+			// top level devices are checked with the top level
+			if devicesKey == nil {
+				continue
+			}
+
+			if first, ok := seen[lower]; ok {
+				problem(name, "device %s is already defined on line %d", name.Value, first.Line)
+			}
+			seen[lower] = name
+
+			for _, p := range validateDevice(c, name.Value, value) {
+				p.message = prefix + p.message
+				problems = append(problems, p)
+			}
+		}
+	}
+
+	if key, groups := findKey(scope, "groups"); groups != nil {
+		if groups.Kind != yaml.MappingNode {
+			problem(key, "groups must be a map of names to lists of devices")
+			return problems
+		}
+		for i := 0; i+1 < len(groups.Content); i += 2 {
+			name, members := groups.Content[i], groups.Content[i+1]
+			if members.Kind != yaml.SequenceNode {
+				problem(members, "group %s must be a list of device names", name.Value)
+				continue
+			}
+			for _, member := range members.Content {
+				if member.Kind != yaml.ScalarNode {
+					problem(member, "group %s must be a list of device names", name.Value)
+					continue
+				}
+				if !names[strings.ToLower(member.Value)] {
+					problem(member, "group %s has unknown device %s", name.Value, member.Value)
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// check a device, which is either an address or a map of settings with a host
+func validateDevice(c *configFile, name string, value *yaml.Node) []configProblem {
+	var problems []configProblem
+	problem := func(node *yaml.Node, format string, a ...interface{}) {
+		problems = append(problems, configProblem{path: c.path, line: node.Line, message: fmt.Sprintf(format, a...)})
+	}
+	warning := func(node *yaml.Node, format string, a ...interface{}) {
+		problem(node, format, a...)
+		problems[len(problems)-1].warning = true
+	}
+
+	switch value.Kind {
+	case yaml.ScalarNode:
+		if err := isHost(value); err != nil {
+			problem(value, "device %s %s", name, err)
+		}
+
+	case yaml.MappingNode:
+		if _, host := findKey(value, "host"); host == nil {
+			problem(value, "device %s has no host", name)
+		}
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			key, setting := value.Content[i], value.Content[i+1]
+			check, ok := deviceSettings[strings.ToLower(key.Value)]
+			if !ok {
+				warning(key, "device %s has unknown setting %s", name, key.Value)
+				continue
+			}
+			if err := check(setting); err != nil {
+				problem(setting, "device %s %s %s", name, key.Value, err)
+			}
+		}
+		if sources := presentKeys(value, secretKeys("password")); len(sources) > 1 {
+			problem(value, "device %s can only have one of %s", name, strings.Join(sources, ", "))
+		}
+
+	default:
+		problem(value, "device %s must be an address or a map with a host", name)
+	}
+
+	return problems
+}
+
+// which of some keys are in a mapping node
+func presentKeys(m *yaml.Node, keys []string) []string {
+	var present []string
+	for _, key := range keys {
+		if k, _ := findKey(m, key); k != nil {
+			present = append(present, key)
+		}
+	}
+	return present
+}
+
+// plain values such as strings and numbers
+func isString(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+		return errors.New("must be a string")
+	}
+	return nil
+}
+
+func isBool(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+		return errors.New("must be true or false")
+	}
+	return nil
+}
+
+// whole numbers from zero up
+func isCount(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!int" {
+		return errors.New("must be a whole number")
+	}
+	if n, err := strconv.Atoi(node.Value); err != nil || n < 0 {
+		return errors.New("must be a whole number")
+	}
+	return nil
+}
+
+// durations such as 500ms or 5s, plain numbers are seconds
+func isDuration(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return errors.New("must be a duration such as 500ms, 5s or 1m")
+	}
+	d, err := toDuration(node.Value)
+	if err != nil {
+		return errors.New("must be a duration such as 500ms, 5s or 1m")
+	}
+	if d < 0 {
+		return errors.New("can't be negative")
+	}
+	return nil
+}
+
+func isHost(node *yaml.Node) error {
+	if isString(node) != nil || checkHost(node.Value) != nil {
+		return fmt.Errorf("\"%s\" is not a valid ip address or hostname", node.Value)
+	}
+	return nil
+}
+
+func isStringList(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		return errors.New("must be a list")
+	}
+	for _, item := range node.Content {
+		if isString(item) != nil {
+			return errors.New("must be a list of strings")
+		}
+	}
+	return nil
+}
+
+func isMac(node *yaml.Node) error {
+	if isString(node) != nil {
+		return errors.New("must be a mac address such as DC:4F:22:12:34:56")
+	}
+	if _, err := net.ParseMAC(node.Value); err != nil {
+		return fmt.Errorf("\"%s\" is not a mac address such as DC:4F:22:12:34:56", node.Value)
+	}
+	return nil
+}
+
+// networks such as 192.168.1.0/24 and single addresses to search for devices
+func isSubnets(node *yaml.Node) error {
+	if isStringList(node) != nil {
+		return errors.New("must be a list of networks such as 192.168.1.0/24")
+	}
+	for _, item := range node.Content {
+		if _, err := scanAddresses([]string{item.Value}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// one of a fixed set of values
+func isOneOf(values ...string) settingCheck {
+	return func(node *yaml.Node) error {
+		for _, v := range values {
+			if node.Kind == yaml.ScalarNode && node.Value == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of: %s", strings.Join(values, ", "))
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// what a secret value is replaced with when displayed
+const secretMask = "********"
+
+// a whole value that refers to an environment variable, e.g. ${LAMP_PASSWORD} or $LAMP_PASSWORD
+var envReference = regexp.MustCompile(`^\$(\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*))$`)
+
+// a secret from the configuration, only read when first needed so commands run against one
+// device don't run the password commands of every device
+type secret struct {
+	source  string // where the secret comes from, for errors
+	resolve func() (string, error)
+
+	once  sync.Once
+	value string
+	err   error
+}
+
+// the value of the secret, reading it the first time
+func (s *secret) Value() (string, error) {
+	s.once.Do(func() {
+		s.value, s.err = s.resolve()
+		if s.err != nil {
+			s.err = fmt.Errorf("could not read %s: %s", s.source, s.err)
+		}
+	})
+	return s.value, s.err
+}
+
+// find a secret in settings, which can be given as:
+//
+//	password: joker
+//	password: ${LAMP_PASSWORD}
+//	password_cmd: pass show tasmota/lamp
+//	password_file: ~/.config/tasmota/lamp
+//
+// returns nil if none is set
+func secretFrom(settings map[string]interface{}, name string) *secret {
+	var sources []string
+	for _, key := range secretKeys(name) {
+		if v, ok := settings[key]; ok && v != nil {
+			sources = append(sources, key)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil
+	}
+	if len(sources) > 1 {
+		return failedSecret(name, fmt.Errorf("only one of %s can be set", strings.Join(sources, ", ")))
+	}
+
+	key := sources[0]
+	value, err := cast.ToStringE(settings[key])
+	if err != nil {
+		return failedSecret(key, errors.New("must be a string"))
+	}
+
+	switch key {
+	case name + "_cmd":
+		return &secret{source: key, resolve: func() (string, error) { return commandSecret(value) }}
+	case name + "_file":
+		return &secret{source: key, resolve: func() (string, error) { return fileSecret(value) }}
+	}
+
+	if m := envReference.FindStringSubmatch(value); m != nil {
+		env := m[2] + m[3]
+		return &secret{source: "$" + env, resolve: func() (string, error) {
+			v, ok := os.LookupEnv(env)
+			if !ok {
+				return "", errors.New("environment variable is not set")
+			}
+			return v, nil
+		}}
+	}
+
+	return &secret{source: key, resolve: func() (string, error) { return value, nil }}
+}
+
+// a secret from the global settings, used by devices that don't have their own
+func globalSecret(name string) *secret {
+	settings := map[string]interface{}{}
+	for _, key := range secretKeys(name) {
+		if viper.IsSet(key) {
+			settings[key] = viper.Get(key)
+		}
+	}
+	return secretFrom(settings, name)
+}
+
+// the settings a secret can be given by
+func secretKeys(name string) []string {
+	return []string{name, name + "_cmd", name + "_file"}
+}
+
+// a secret that can't be read because of a problem with its settings
+func failedSecret(source string, err error) *secret {
+	return &secret{source: source, resolve: func() (string, error) { return "", err }}
+}
+
+// run a command such as pass, using the first line of its output like pass does
+func commandSecret(command string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := shellCommand(command)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %s", err, msg)
+		}
+		return "", err
+	}
+	return firstLine(out), nil
+}
+
+// a command run by the shell, cmd on windows and sh everywhere else
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// read the first line of a file
+func fileSecret(path string) (string, error) {
+	b, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		return "", err
+	}
+	return firstLine(b), nil
+}
+
+// a path with a leading ~/ taken as the home directory
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(homeDirName, path[2:])
+	}
+	return path
+}
+
+// the first line of some output, without the line ending
+func firstLine(b []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	if scanner.Scan() {
+		return strings.TrimRight(scanner.Text(), "\r")
+	}
+	return ""
+}
+
+// check if a setting holds a secret value, rather than where to find one
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	return key == "password" || strings.HasSuffix(key, "_password")
+}
+
+// copy of settings with secret values masked, for displaying
+func maskSecrets(settings map[string]interface{}) map[string]interface{} {
+	masked := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		if m, ok := v.(map[string]interface{}); ok {
+			masked[k] = maskSecrets(m)
+		} else if isSecretKey(k) && v != nil {
+			masked[k] = secretMask
+		} else {
+			masked[k] = v
+		}
+	}
+	return masked
+}
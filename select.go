@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a step in a --select path, either a field name or an array index, * matches everything
+type selectStep struct {
+	key     string
+	index   string
+	isIndex bool
+}
+
+// a value found by a --select path
+type selectMatch struct {
+	path  string
+	value interface{}
+}
+
+// parse a --select path such as StatusSNS.ENERGY.Power, Status.FriendlyName[0] or StatusSNS.*.Temperature
+func parseSelect(path string) ([]selectStep, error) {
+	var steps []selectStep
+
+	for _, segment := range strings.Split(path, ".") {
+		name := segment
+		indexes := ""
+		if i := strings.Index(segment, "["); i >= 0 {
+			name = segment[:i]
+			indexes = segment[i:]
+		}
+
+		if name == "" && indexes == "" {
+			return nil, fmt.Errorf("invalid select path %s: empty field name", path)
+		}
+		if name != "" {
+			steps = append(steps, selectStep{key: name})
+		}
+
+		for indexes != "" {
+			end := strings.Index(indexes, "]")
+			if !strings.HasPrefix(indexes, "[") || end < 0 {
+				return nil, fmt.Errorf("invalid select path %s: bad index in %s", path, segment)
+			}
+
+			index := indexes[1:end]
+			if _, err := strconv.Atoi(index); err != nil && index != "*" {
+				return nil, fmt.Errorf("invalid select path %s: index must be a number or *", path)
+			}
+
+			steps = append(steps, selectStep{index: index, isIndex: true})
+			indexes = indexes[end+1:]
+		}
+	}
+
+	return steps, nil
+}
+
+// find all values matching the steps of a --select path
+func collectMatches(value interface{}, steps []selectStep, path string, matches *[]selectMatch) {
+	if len(steps) == 0 {
+		*matches = append(*matches, selectMatch{path: path, value: value})
+		return
+	}
+
+	step := steps[0]
+
+	if step.isIndex {
+		list, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range list {
+			if step.index == "*" || step.index == strconv.Itoa(i) {
+				collectMatches(item, steps[1:], fmt.Sprintf("%s[%d]", path, i), matches)
+			}
+		}
+		return
+	}
+
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if step.key == "*" {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			collectMatches(fields[k], steps[1:], joinPath(path, k), matches)
+		}
+		return
+	}
+
+	if field, ok := fields[step.key]; ok {
+		collectMatches(field, steps[1:], joinPath(path, step.key), matches)
+		return
+	}
+
+	// tasmota isn't consistent with capitalisation, so fall back to ignoring case
+	for k, field := range fields {
+		if strings.EqualFold(k, step.key) {
+			collectMatches(field, steps[1:], joinPath(path, k), matches)
+			return
+		}
+	}
+}
+
+// output of the values selected from another output by a --select path
+func selectOutput(o output, path string) (output, error) {
+	steps, err := parseSelect(path)
+	if err != nil {
+		return output{}, err
+	}
+
+	data, err := o.source()
+	if err != nil {
+		return output{}, err
+	}
+
+	var matches []selectMatch
+	collectMatches(data, steps, "", &matches)
+	if len(matches) == 0 {
+		return output{}, fmt.Errorf("no fields match %s", path)
+	}
+
+	selected := output{Columns: []string{"Field", "Value"}}
+	values := make([]interface{}, len(matches))
+	var lines []string
+	scalars := true
+
+	for i, m := range matches {
+		values[i] = m.value
+
+		rows, err := flatten(m.value)
+		if err != nil {
+			return output{}, err
+		}
+		for _, row := range rows {
+			switch {
+			case row[0] == "":
+				row[0] = m.path
+			case strings.HasPrefix(row[0], "["):
+				row[0] = m.path + row[0]
+			default:
+				row[0] = joinPath(m.path, row[0])
+			}
+			selected.Rows = append(selected.Rows, row)
+		}
+
+		switch v := m.value.(type) {
+		case map[string]interface{}, []interface{}:
+			scalars = false
+		case nil:
+			lines = append(lines, "null")
+		default:
+			lines = append(lines, fmt.Sprint(v))
+		}
+	}
+
+	selected.Data = values
+	if len(values) == 1 {
+		selected.Data = values[0]
+	}
+
+	// print plain values so scripts get 42.1 rather than a json blob
+	if scalars {
+		selected.Text = strings.Join(lines, "\n") + "\n"
+		selected.Raw = []byte(selected.Text)
+		return selected, nil
+	}
+
+	s, err := json.MarshalIndent(selected.Data, "", "\t")
+	if err != nil {
+		return output{}, err
+	}
+	selected.Text = string(s) + "\n"
+	selected.Raw, err = json.Marshal(selected.Data)
+
+	return selected, err
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// a session of requests to devices in HAR format, which browsers and HAR viewers can open:
+// http://www.softwareishard.com/blog/har-12-spec/
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"` // milliseconds
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+
+	// requests that failed without a response, HAR allows custom fields starting with _
+	Error   string `json:"_error,omitempty"`
+	Timeout bool   `json:"_timeout,omitempty"`
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []harHeader `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []harHeader `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	Content     harContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// set up recording or replaying of requests to devices, if asked to
+func setupSession() error {
+	record := viper.GetString("record")
+	replay := viper.GetString("replay")
+
+	switch {
+	case record != "" && replay != "":
+		return errors.New("--record and --replay can't be used together")
+	case record != "":
+		httpClient.Transport = &recorder{path: record, next: http.DefaultTransport}
+	case replay != "":
+		r, err := loadReplay(replay)
+		if err != nil {
+			return err
+		}
+		httpClient.Transport = r
+	}
+	return nil
+}
+
+// records every request and response, writing the session after each one so it is complete
+// even if the command exits with an error or is interrupted
+type recorder struct {
+	path string
+	next http.RoundTripper
+
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := r.next.RoundTrip(req)
+
+	entry := harEntry{
+		StartedDateTime: start.Format(time.RFC3339Nano),
+		Request:         newHarRequest(req),
+		Response:        harResponse{Cookies: []harHeader{}, Headers: []harHeader{}, HeadersSize: -1, BodySize: -1},
+	}
+
+	if err != nil {
+		var netErr net.Error
+		entry.Error = err.Error()
+		entry.Timeout = errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+	} else {
+		// read the body here so its latency is recorded, and hand a copy on, failing the same way
+		body, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), &failedReader{readErr}))
+		if readErr != nil {
+			entry.Error = readErr.Error()
+		}
+
+		entry.Response.Status = resp.StatusCode
+		entry.Response.StatusText = http.StatusText(resp.StatusCode)
+		entry.Response.HTTPVersion = resp.Proto
+		entry.Response.BodySize = len(body)
+		entry.Response.Content = harContent{Size: len(body), MimeType: resp.Header.Get("Content-Type"), Text: string(body)}
+		for _, name := range sortedNames(resp.Header) {
+			for _, value := range resp.Header[name] {
+				entry.Response.Headers = append(entry.Response.Headers, harHeader{Name: name, Value: value})
+			}
+		}
+	}
+
+	latency := float64(time.Since(start).Microseconds()) / 1000
+	entry.Time = latency
+	entry.Timings.Wait = latency
+
+	if saveErr := r.save(entry); saveErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not record session: %s\n", saveErr)
+	}
+	return resp, err
+}
+
+// add an entry and write the whole session
+func (r *recorder) save(entry harEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, entry)
+	session := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: applicationName, Version: applicationVersion},
+		Entries: r.entries,
+	}}
+
+	b, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	// sessions hold device settings, so keep them private like the configuration
+	return os.WriteFile(r.path, append(b, '\n'), 0600)
+}
+
+// the recorded form of a request, without the password
+func newHarRequest(req *http.Request) harRequest {
+	u := redactURL(req.URL)
+
+	query := []harHeader{}
+	values := u.Query()
+	for _, name := range sortedNames(values) {
+		for _, value := range values[name] {
+			query = append(query, harHeader{Name: name, Value: value})
+		}
+	}
+
+	return harRequest{
+		Method:      req.Method,
+		URL:         u.String(),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harHeader{},
+		Headers:     []harHeader{},
+		QueryString: query,
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+}
+
+// sorted names of headers or query parameters
+func sortedNames(values map[string][]string) []string {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// a reader that fails with err, or ends if there is no error
+type failedReader struct {
+	err error
+}
+
+func (r *failedReader) Read([]byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, io.EOF
+}
+
+// copy of a url with the password parameter and the values of password commands masked, basic
+// auth is a header so never makes it into the url
+func redactURL(u *url.URL) *url.URL {
+	redacted := *u
+	redacted.User = nil
+
+	query := u.Query()
+	if query.Has("password") {
+		query.Set("password", secretMask)
+	}
+	if query.Has("cmnd") {
+		query.Set("cmnd", redactCommand(query.Get("cmnd")))
+	}
+	redacted.RawQuery = query.Encode()
+	return &redacted
+}
+
+// mask the values of commands that set passwords, such as WebPassword or MqttPassword, including
+// inside a backlog
+func redactCommand(command string) string {
+	name, rest, _ := strings.Cut(strings.TrimSpace(command), " ")
+	if strings.EqualFold(name, "backlog") {
+		parts := strings.Split(rest, ";")
+		for i, part := range parts {
+			parts[i] = redactCommand(part)
+		}
+		return name + " " + strings.Join(parts, "; ")
+	}
+
+	if rest != "" && strings.HasSuffix(strings.ToLower(strings.TrimRight(name, "0123456789")), "password") {
+		return name + " " + secretMask
+	}
+	return strings.TrimSpace(command)
+}
+
+// serves recorded responses instead of making requests, each request gets the next response
+// recorded for the same url, and the last one again once they run out, so polling keeps working
+type replayer struct {
+	mu        sync.Mutex
+	responses map[string][]harEntry
+}
+
+// read a recorded session
+func loadReplay(path string) (*replayer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var session harFile
+	if err := json.Unmarshal(b, &session); err != nil {
+		return nil, fmt.Errorf("%s is not a recorded session: %s", path, err)
+	}
+
+	r := &replayer{responses: map[string][]harEntry{}}
+	for _, entry := range session.Log.Entries {
+		key := entry.Request.Method + " " + entry.Request.URL
+		r.responses[key] = append(r.responses[key], entry)
+	}
+	return r, nil
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.Method + " " + redactURL(req.URL).String()
+
+	r.mu.Lock()
+	entries := r.responses[key]
+	if len(entries) == 0 {
+		r.mu.Unlock()
+		return nil, errors.New("no response was recorded for this request")
+	}
+	entry := entries[0]
+	if len(entries) > 1 {
+		r.responses[key] = entries[1:]
+	}
+	r.mu.Unlock()
+
+	if entry.Timeout {
+		return nil, replayTimeout(entry.Error)
+	}
+	if entry.Error != "" && entry.Response.Status == 0 {
+		return nil, errors.New(entry.Error)
+	}
+
+	header := http.Header{}
+	for _, h := range entry.Response.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Response.Status, entry.Response.StatusText),
+		StatusCode:    entry.Response.Status,
+		Proto:         entry.Response.HTTPVersion,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(entry.Response.Content.Text)),
+		ContentLength: int64(len(entry.Response.Content.Text)),
+		Request:       req,
+	}, nil
+}
+
+// a recorded timeout, which is reported as a timeout again
+type replayTimeout string
+
+func (e replayTimeout) Error() string   { return string(e) }
+func (e replayTimeout) Timeout() bool   { return true }
+func (e replayTimeout) Temporary() bool { return true }
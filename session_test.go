@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://lamp/cm?cmnd=Power%20On", "http://lamp/cm?cmnd=Power+On"},
+		{"http://lamp/cm?user=admin&password=joker&cmnd=Status0", "http://lamp/cm?cmnd=Status0&password=%2A%2A%2A%2A%2A%2A%2A%2A&user=admin"},
+		{"http://lamp/cm?cmnd=WebPassword%20joker", "http://lamp/cm?cmnd=WebPassword+%2A%2A%2A%2A%2A%2A%2A%2A"},
+		{"http://lamp/cm?cmnd=MqttPassword2%20joker", "http://lamp/cm?cmnd=MqttPassword2+%2A%2A%2A%2A%2A%2A%2A%2A"},
+		{"http://lamp/cm?cmnd=Backlog%20Dimmer%2010%3B%20WebPassword%20joker", "http://lamp/cm?cmnd=Backlog+Dimmer+10%3B+WebPassword+%2A%2A%2A%2A%2A%2A%2A%2A"},
+		{"http://lamp/cs?c2=3", "http://lamp/cs?c2=3"},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redactURL(u).String(); got != tt.want {
+			t.Errorf("redactURL(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
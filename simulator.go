@@ -0,0 +1,944 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// number of timers and rules a tasmota device has
+const (
+	simTimers = 16
+	simRules  = 3
+)
+
+// number of lines kept in the simulated web log
+const simLogSize = 100
+
+// how long a simulated wifi scan takes
+const simScanTime = 500 * time.Millisecond
+
+// number of simulated devices made, giving each its own mac address
+var simCount uint32
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Run a simulated tasmota device for trying out commands without real devices",
+	Long: `Run a simulated tasmota device that answers commands like a real one, keeping the state
+of its relays, dimmer, colour, timers and rules until it is stopped.
+
+Faults can be added with --fault to see how commands and scripts cope with unreliable devices:
+
+  latency=300ms             every request takes 300ms longer
+  timeout                   requests never get a response
+  reset                     connections are reset
+  401, 500, ...             requests fail with an http status
+  truncated                 responses are cut off half way
+  malformed                 responses are invalid json
+  reboot=2s                 the device restarts instead of answering, taking 2s
+
+followed by options choosing the requests they happen to:
+
+  after=n                   let the first n requests through
+  times=n                   only happen n times
+  every=n                   only happen to every nth request
+  command=name              only happen to this command, e.g. power`,
+	Example: `  tasmota-cli simulate --listen 127.0.0.1:8080 --relays 2
+  tasmota-cli status --host 127.0.0.1:8080
+  tasmota-cli simulate --fault timeout,times=2 --fault 500,command=timers`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var faults []*simFault
+		specs, _ := cmd.Flags().GetStringArray("fault")
+		for _, spec := range specs {
+			f, err := parseFault(spec)
+			if err != nil {
+				return err
+			}
+			faults = append(faults, f)
+		}
+
+		listener, err := net.Listen("tcp", viper.GetString("listen"))
+		if err != nil {
+			return err
+		}
+
+		sim := newSimulator(viper.GetString("name"), viper.GetInt("relays"))
+		// not bound to viper, which would fall back to the password of the configuration
+		sim.password, _ = cmd.Flags().GetString("password")
+		for _, f := range faults {
+			sim.addFault(f)
+		}
+
+		fmt.Printf("Simulating %s with %d relays on %s\n", sim.name, len(sim.power), listener.Addr())
+		return http.Serve(listener, sim)
+	},
+}
+
+func init() {
+	simulateCmd.Flags().String("listen", "127.0.0.1:8080", "Address to listen on")
+	simulateCmd.Flags().String("name", "Tasmota", "Name of the simulated device")
+	simulateCmd.Flags().Int("relays", 1, "Number of relays")
+	simulateCmd.Flags().String("password", "", "Web admin password to require")
+	unbindFlag(simulateCmd.Flags(), "password")
+	simulateCmd.Flags().StringArray("fault", nil, "Fault to simulate, e.g. timeout,times=2 or latency=300ms, can be repeated")
+
+	rootCmd.AddCommand(simulateCmd)
+}
+
+// a rule set of a simulated device
+type simRule struct {
+	Enabled     bool
+	Once        bool
+	StopOnError bool
+	Text        string
+}
+
+// the wifi connection of a simulated device
+type simWifi struct {
+	ssid      string
+	bssid     string
+	channel   int
+	signal    int // dBm
+	linkCount int // connections since restarting
+	password  string
+	addresses [4]string // ip address, gateway, netmask and dns server, 0.0.0.0 uses dhcp
+}
+
+// the mqtt settings of a simulated device, it is connected whenever it has a host
+type simMqtt struct {
+	host       string
+	port       int
+	user       string
+	password   string
+	client     string
+	topic      string
+	fullTopic  string
+	groupTopic string
+}
+
+// a network found by a simulated wifi scan
+type simNetwork struct {
+	ssid       string
+	bssid      string
+	channel    int
+	signal     int
+	encryption string
+}
+
+// networks a simulated wifi scan finds besides the one the device is connected to
+var simNeighbours = []simNetwork{
+	{ssid: "neighbour", bssid: "11:22:33:44:55:66", channel: 1, signal: -82, encryption: "WPA2/PSK"},
+	{ssid: "guest", bssid: "AA:BB:CC:DD:EE:00", channel: 11, signal: -58, encryption: "OPEN"},
+}
+
+// a line in the simulated web log
+type simLogLine struct {
+	index int
+	text  string
+}
+
+// a simulated tasmota device, serving /cm and /cs like the tasmota web server does, for use with
+// httptest or the simulate command
+type simulator struct {
+	mu sync.Mutex
+
+	name     string
+	password string // user admin must give this password when set
+	mac      string
+	started  time.Time
+
+	power   []bool // one per relay
+	dimmer  int
+	color   [3]int
+	timers  [simTimers]Timer
+	enabled bool // timers are enabled
+	rules   [simRules]simRule
+	webLog  int
+	sysLog  int
+	logHost string
+	logPort int
+	wifi    simWifi
+	mqtt    simMqtt
+
+	scanStarted time.Time // when the last wifi scan was started, zero if there hasn't been one
+
+	log      []simLogLine
+	logIndex int
+
+	faults    []*simFault
+	bootUntil time.Time // when a reboot fault finishes restarting
+}
+
+// a simulated device with some relays, all off
+func newSimulator(name string, relays int) *simulator {
+	if relays < 1 {
+		relays = 1
+	}
+
+	n := atomic.AddUint32(&simCount, 1)
+	s := &simulator{
+		name:    name,
+		mac:     fmt.Sprintf("DC:4F:22:00:%02X:%02X", n>>8&0xFF, n&0xFF),
+		started: time.Now(),
+		power:   make([]bool, relays),
+		dimmer:  100,
+		color:   [3]int{255, 255, 255},
+		enabled: true,
+		webLog:  2,
+		logPort: 514,
+		wifi: simWifi{
+			ssid: "simulated", bssid: "AA:BB:CC:DD:EE:FF", channel: 6, signal: -65, linkCount: 1,
+			addresses: [4]string{"0.0.0.0", "192.168.1.1", "255.255.255.0", "192.168.1.1"},
+		},
+		mqtt: simMqtt{
+			port: 1883, user: "DVES_USER", client: fmt.Sprintf("DVES_%06X", n),
+			topic: strings.ToLower(name), fullTopic: "%prefix%/%topic%/", groupTopic: "tasmotas",
+		},
+	}
+	for i := range s.timers {
+		s.timers[i] = Timer{Time: "00:00", Days: "0000000", Output: 1}
+	}
+	s.addLog("APP: Restarted")
+	return s
+}
+
+func (s *simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.serveFaults(w, r) {
+		s.serve(w, r)
+	}
+}
+
+// serve a request as a working device would
+func (s *simulator) serve(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/cm":
+		s.serveCommand(w, r)
+	case "/cs":
+		s.serveConsole(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// run commands given by ?cmnd=
+func (s *simulator) serveCommand(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.password != "" && (query.Get("user") != "admin" || query.Get("password") != s.password) {
+		fmt.Fprint(w, `{"WARNING":"Need user=<username>&password=<password>"}`)
+		return
+	}
+
+	command := strings.TrimSpace(query.Get("cmnd"))
+	response := s.run(command)
+
+	b, _ := json.Marshal(response)
+	s.addLog("CMD: " + command)
+	s.addLog("RSL: RESULT = " + string(b))
+	w.Write(b)
+}
+
+// web console log lines after ?c2=
+//
+// the response looks like: <next index>}1<reset flag>}1<lines separated by \n>}1
+func (s *simulator) serveConsole(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.password != "" {
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != s.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	index, _ := strconv.Atoi(r.URL.Query().Get("c2"))
+
+	// start again if the index is from before the oldest line kept
+	reset := 0
+	if index == 0 || (len(s.log) > 0 && index < s.log[0].index-1) {
+		reset = 1
+		index = 0
+	}
+
+	var lines []string
+	for _, line := range s.log {
+		if line.index > index {
+			lines = append(lines, line.text)
+		}
+	}
+	fmt.Fprintf(w, "%d}1%d}1%s}1", s.logIndex, reset, strings.Join(lines, "\n"))
+}
+
+// add a line to the web log
+func (s *simulator) addLog(text string) {
+	s.logIndex++
+	s.log = append(s.log, simLogLine{index: s.logIndex, text: time.Now().Format("15:04:05.000") + " " + text})
+	if len(s.log) > simLogSize {
+		s.log = s.log[len(s.log)-simLogSize:]
+	}
+}
+
+// run a command, returning the json response
+func (s *simulator) run(command string) map[string]interface{} {
+	name, index, payload := splitCommand(command)
+
+	switch name {
+	case "backlog":
+		// responses of every command in one
+		response := map[string]interface{}{}
+		for _, c := range strings.Split(payload, ";") {
+			if c = strings.TrimSpace(c); c != "" {
+				for k, v := range s.run(c) {
+					response[k] = v
+				}
+			}
+		}
+		return response
+
+	case "power":
+		return s.runPower(index, payload)
+
+	case "status":
+		// Status0 is the same as Status 0
+		if payload == "" && index >= 0 {
+			payload = strconv.Itoa(index)
+		}
+		return s.status(payload)
+
+	case "dimmer":
+		if payload != "" {
+			n, err := strconv.Atoi(payload)
+			if err != nil || n < 0 || n > 100 {
+				return simError("Dimmer", "0..100")
+			}
+			s.dimmer = n
+			s.setAll(n > 0)
+		}
+		return map[string]interface{}{s.powerKey(1): onOff(s.power[0]), "Dimmer": s.dimmer}
+
+	case "color":
+		if payload != "" {
+			color, ok := parseColor(payload)
+			if !ok {
+				return simError("Color", "RRGGBB or R,G,B")
+			}
+			s.color = color
+			s.setAll(true)
+		}
+		return map[string]interface{}{
+			s.powerKey(1): onOff(s.power[0]),
+			"Dimmer":      s.dimmer,
+			"Color":       fmt.Sprintf("%02X%02X%02X", s.color[0], s.color[1], s.color[2]),
+			"Channel":     []int{s.color[0] * 100 / 255, s.color[1] * 100 / 255, s.color[2] * 100 / 255},
+		}
+
+	case "timers":
+		if on, ok := parseOnOff(payload); ok {
+			s.enabled = on
+		}
+		response := map[string]interface{}{"Timers": onOff(s.enabled)}
+		for i, t := range s.timers {
+			response[fmt.Sprintf("Timer%d", i+1)] = t
+		}
+		return response
+
+	case "timer":
+		if index < 1 || index > simTimers {
+			return unknownCommand()
+		}
+		timer := &s.timers[index-1]
+		if payload != "" {
+			if payload == "0" {
+				*timer = Timer{Time: "00:00", Days: "0000000", Output: 1}
+			} else if err := json.Unmarshal([]byte(payload), timer); err != nil {
+				return simError(fmt.Sprintf("Timer%d", index), "a json timer")
+			}
+		}
+		return map[string]interface{}{fmt.Sprintf("Timer%d", index): *timer}
+
+	case "rule":
+		if index < 1 || index > simRules {
+			return unknownCommand()
+		}
+		rule := &s.rules[index-1]
+		switch lower := strings.ToLower(payload); {
+		case payload == "":
+		case payload == `""`:
+			rule.Text = ""
+		case lower == "4" || lower == "5":
+			rule.Once = lower == "5"
+		case lower == "8" || lower == "9":
+			rule.StopOnError = lower == "9"
+		default:
+			if on, ok := parseOnOff(payload); ok {
+				rule.Enabled = on
+			} else if strings.HasPrefix(payload, "+") {
+				rule.Text = strings.TrimSpace(rule.Text + " " + strings.TrimSpace(payload[1:]))
+			} else {
+				rule.Text = payload
+			}
+		}
+		return map[string]interface{}{fmt.Sprintf("Rule%d", index): map[string]interface{}{
+			"State":       onOff(rule.Enabled),
+			"Once":        onOff(rule.Once),
+			"StopOnError": onOff(rule.StopOnError),
+			"Length":      len(rule.Text),
+			"Free":        511 - len(rule.Text),
+			"Rules":       rule.Text,
+		}}
+
+	case "weblog":
+		return map[string]interface{}{"WebLog": setLevel(&s.webLog, payload)}
+
+	case "syslog":
+		return map[string]interface{}{"SysLog": setLevel(&s.sysLog, payload)}
+
+	case "loghost":
+		if payload != "" {
+			s.logHost = payload
+		}
+		return map[string]interface{}{"LogHost": s.logHost}
+
+	case "logport":
+		if n, err := strconv.Atoi(payload); err == nil {
+			s.logPort = n
+		}
+		return map[string]interface{}{"LogPort": s.logPort}
+
+	case "wifiscan":
+		return s.wifiScan(payload)
+
+	case "ssid":
+		if index != 1 {
+			return unknownCommand()
+		}
+		if payload != "" {
+			s.wifi.ssid = payload
+		}
+		return map[string]interface{}{"SSId1": s.wifi.ssid}
+
+	case "password":
+		if index != 1 {
+			return unknownCommand()
+		}
+		if payload != "" {
+			s.wifi.password = payload
+		}
+		// tasmota never shows the password
+		return map[string]interface{}{"Password1": strings.Repeat("*", len(s.wifi.password))}
+
+	case "mqtthost", "mqttuser", "mqttpassword", "mqttport", "topic", "fulltopic", "grouptopic":
+		return s.runMqtt(name, index, payload)
+
+	case "ipaddress":
+		if index < 1 || index > len(s.wifi.addresses) {
+			return unknownCommand()
+		}
+		key := fmt.Sprintf("IPAddress%d", index)
+		if payload != "" {
+			if ip := net.ParseIP(payload); ip == nil || ip.To4() == nil {
+				return simError(key, "an ip address")
+			}
+			s.wifi.addresses[index-1] = payload
+		}
+		return map[string]interface{}{key: s.wifi.addresses[index-1]}
+	}
+
+	return unknownCommand()
+}
+
+// switch relays, Power0 is every relay and Power is the first
+func (s *simulator) runPower(index int, payload string) map[string]interface{} {
+	relays := []int{index}
+	switch {
+	case index == 0:
+		relays = nil
+		for i := range s.power {
+			relays = append(relays, i+1)
+		}
+	case index < 0:
+		relays = []int{1}
+	case index > len(s.power):
+		return unknownCommand()
+	}
+
+	response := map[string]interface{}{}
+	for _, relay := range relays {
+		state := &s.power[relay-1]
+		switch strings.ToLower(payload) {
+		case "":
+		case "on", "1":
+			*state = true
+		case "off", "0":
+			*state = false
+		case "toggle", "2":
+			*state = !*state
+		default:
+			return simError(s.powerKey(relay), "ON, OFF or TOGGLE")
+		}
+		response[s.powerKey(relay)] = onOff(*state)
+	}
+	return response
+}
+
+// switch every relay
+func (s *simulator) setAll(on bool) {
+	for i := range s.power {
+		s.power[i] = on
+	}
+}
+
+// POWER on devices with one relay, POWER1, POWER2, etc on others
+func (s *simulator) powerKey(relay int) string {
+	if len(s.power) == 1 {
+		return "POWER"
+	}
+	return fmt.Sprintf("POWER%d", relay)
+}
+
+// power state of every relay as a bitmask, as status shows it
+func (s *simulator) powerMask() int {
+	mask := 0
+	for i, on := range s.power {
+		if on {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
+
+// response to status, with no payload only the Status section is returned
+func (s *simulator) status(payload string) map[string]interface{} {
+	sections := map[string]func() interface{}{
+		"Status":    s.statusMain,
+		"StatusPRM": s.statusPRM,
+		"StatusFWR": s.statusFWR,
+		"StatusLOG": s.statusLOG,
+		"StatusMEM": s.statusMEM,
+		"StatusNET": s.statusNET,
+		"StatusMQT": s.statusMQT,
+		"StatusTIM": s.statusTIM,
+		"StatusPTH": s.statusPTH,
+		"StatusSNS": s.statusSNS,
+		"StatusSTS": s.statusSTS,
+	}
+
+	// sections returned by each status number
+	numbered := map[string][]string{
+		"":   {"Status"},
+		"0":  {"Status", "StatusPRM", "StatusFWR", "StatusLOG", "StatusMEM", "StatusNET", "StatusMQT", "StatusTIM", "StatusPTH", "StatusSNS", "StatusSTS"},
+		"1":  {"StatusPRM"},
+		"2":  {"StatusFWR"},
+		"3":  {"StatusLOG"},
+		"4":  {"StatusMEM"},
+		"5":  {"StatusNET"},
+		"6":  {"StatusMQT"},
+		"7":  {"StatusTIM"},
+		"8":  {"StatusSNS"},
+		"9":  {"StatusPTH"},
+		"10": {"StatusSNS"},
+		"11": {"StatusSTS"},
+	}
+
+	names, ok := numbered[payload]
+	if !ok {
+		return unknownCommand()
+	}
+
+	response := map[string]interface{}{}
+	for _, name := range names {
+		response[name] = sections[name]()
+	}
+	return response
+}
+
+func (s *simulator) statusMain() interface{} {
+	return map[string]interface{}{
+		"Module":       1,
+		"DeviceName":   s.name,
+		"FriendlyName": []string{s.name},
+		"Topic":        s.mqtt.topic,
+		"ButtonTopic":  "0",
+		"Power":        s.powerMask(),
+		"PowerOnState": 3,
+		"LedState":     1,
+		"LedMask":      "FFFF",
+		"SaveData":     1,
+		"SaveState":    1,
+		"SwitchTopic":  "0",
+		"SwitchMode":   []int{0, 0, 0, 0, 0, 0, 0, 0},
+		"ButtonRetain": 0,
+		"SwitchRetain": 0,
+		"SensorRetain": 0,
+		"PowerRetain":  0,
+		"InfoRetain":   0,
+		"StateRetain":  0,
+	}
+}
+
+func (s *simulator) statusPRM() interface{} {
+	return map[string]interface{}{
+		"Baudrate":      115200,
+		"SerialConfig":  "8N1",
+		"GroupTopic":    s.mqtt.groupTopic,
+		"OtaUrl":        "http://ota.tasmota.com/tasmota/release/tasmota.bin.gz",
+		"RestartReason": "Software/System restart",
+		"Uptime":        s.uptime(),
+		"StartupUTC":    s.started.UTC().Format("2006-01-02T15:04:05"),
+		"Sleep":         50,
+		"CfgHolder":     4617,
+		"BootCount":     12,
+		"BCResetTime":   "2022-01-01T00:00:00",
+		"SaveCount":     42,
+		"SaveAddress":   "F5000",
+	}
+}
+
+func (s *simulator) statusFWR() interface{} {
+	return map[string]interface{}{
+		"Version":       "12.1.1(tasmota)",
+		"BuildDateTime": "2022-08-25T11:33:55",
+		"Boot":          31,
+		"Core":          "2_7_4_9",
+		"SDK":           "2.2.2-dev(38a443e)",
+		"CpuFrequency":  80,
+		"Hardware":      "ESP8266EX",
+		"CR":            "378/699",
+	}
+}
+
+func (s *simulator) statusLOG() interface{} {
+	return map[string]interface{}{
+		"SerialLog":  0,
+		"WebLog":     s.webLog,
+		"MqttLog":    0,
+		"SysLog":     s.sysLog,
+		"LogHost":    s.logHost,
+		"LogPort":    s.logPort,
+		"SSId":       []string{s.wifi.ssid, ""},
+		"TelePeriod": 300,
+		"Resolution": "558180C0",
+		"SetOption":  []string{"00008009", "2805C80001000600003C5A0A190000000000", "00000080", "00006000", "00004000"},
+	}
+}
+
+func (s *simulator) statusMEM() interface{} {
+	return map[string]interface{}{
+		"ProgramSize":      620,
+		"Free":             380,
+		"Heap":             26,
+		"ProgramFlashSize": 1024,
+		"FlashSize":        1024,
+		"FlashChipId":      "14405E",
+		"FlashFrequency":   40,
+		"FlashMode":        3,
+		"Features":         []string{"00000809", "8FDAC787", "04368001", "000000CF", "010013C0", "C000F981", "00004004", "00001000", "04000020"},
+		"Drivers":          "1,2,3,4,5,6,7,8,9,10,12,16,18,19,20,21,22,24,26,27,29,30,35,37,45,62",
+		"Sensors":          "1,2,3,4,5,6",
+	}
+}
+
+func (s *simulator) statusNET() interface{} {
+	return map[string]interface{}{
+		"Hostname":   fmt.Sprintf("%s-%04X", strings.ToLower(s.name), s.macSuffix()),
+		"IPAddress":  "127.0.0.1",
+		"Gateway":    "127.0.0.1",
+		"Subnetmask": "255.0.0.0",
+		"DNSServer1": "127.0.0.1",
+		"DNSServer2": "0.0.0.0",
+		"Mac":        s.mac,
+		"Webserver":  2,
+		"HTTP_API":   1,
+		"WifiConfig": 4,
+		"WifiPower":  17.0,
+	}
+}
+
+func (s *simulator) statusMQT() interface{} {
+	return map[string]interface{}{
+		"MqttHost":        s.mqtt.host,
+		"MqttPort":        s.mqtt.port,
+		"MqttClientMask":  "DVES_%06X",
+		"MqttClient":      s.mqtt.client,
+		"MqttUser":        s.mqtt.user,
+		"MqttCount":       s.mqttCount(),
+		"MAX_PACKET_SIZE": 1200,
+		"KEEPALIVE":       30,
+		"SOCKET_TIMEOUT":  4,
+	}
+}
+
+func (s *simulator) statusTIM() interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"UTC":      now.UTC().Format("2006-01-02T15:04:05"),
+		"Local":    now.Format("2006-01-02T15:04:05"),
+		"StartDST": "2022-03-27T02:00:00",
+		"EndDST":   "2022-10-30T03:00:00",
+		"Timezone": 99,
+		"Sunrise":  "06:55",
+		"Sunset":   "18:40",
+	}
+}
+
+// power thresholds of the energy monitor, all off as they are by default
+func (s *simulator) statusPTH() interface{} {
+	return map[string]interface{}{
+		"PowerDelta":  []int{0},
+		"PowerLow":    0,
+		"PowerHigh":   0,
+		"VoltageLow":  0,
+		"VoltageHigh": 0,
+		"CurrentLow":  0,
+		"CurrentHigh": 0,
+	}
+}
+
+func (s *simulator) statusSNS() interface{} {
+	return map[string]interface{}{
+		"Time":    time.Now().Format("2006-01-02T15:04:05"),
+		"Switch1": "OFF",
+		"ENERGY": map[string]interface{}{
+			"Total":     12.345,
+			"Yesterday": 0.5,
+			"Today":     0.25,
+			"Power":     s.powerMask() * 42,
+			"Voltage":   230,
+			"Current":   float64(s.powerMask()*42) / 230,
+		},
+	}
+}
+
+func (s *simulator) statusSTS() interface{} {
+	sts := map[string]interface{}{
+		"Time":      time.Now().Format("2006-01-02T15:04:05"),
+		"Uptime":    s.uptime(),
+		"UptimeSec": int(time.Since(s.started).Seconds()),
+		"Heap":      26,
+		"SleepMode": "Dynamic",
+		"Sleep":     50,
+		"LoadAvg":   19,
+		"MqttCount": s.mqttCount(),
+		"Dimmer":    s.dimmer,
+		"Wifi": map[string]interface{}{
+			"AP":        1,
+			"SSId":      s.wifi.ssid,
+			"BSSId":     s.wifi.bssid,
+			"Channel":   s.wifi.channel,
+			"Mode":      "11n",
+			"RSSI":      wifiQuality(s.wifi.signal),
+			"Signal":    s.wifi.signal,
+			"LinkCount": s.wifi.linkCount,
+			"Downtime":  "0T00:00:03",
+		},
+	}
+	for i, on := range s.power {
+		sts[s.powerKey(i+1)] = onOff(on)
+	}
+	return sts
+}
+
+// set or show an mqtt setting, tasmota never shows the password
+func (s *simulator) runMqtt(name string, index int, payload string) map[string]interface{} {
+	settings := map[string]struct {
+		key   string
+		value *string
+	}{
+		"mqtthost":     {"MqttHost", &s.mqtt.host},
+		"mqttuser":     {"MqttUser", &s.mqtt.user},
+		"mqttpassword": {"MqttPassword", &s.mqtt.password},
+		"topic":        {"Topic", &s.mqtt.topic},
+		"fulltopic":    {"FullTopic", &s.mqtt.fullTopic},
+		"grouptopic":   {"GroupTopic1", &s.mqtt.groupTopic},
+	}
+
+	if name == "mqttport" {
+		if payload != "" {
+			n, err := strconv.Atoi(payload)
+			if err != nil || n < 1 || n > 65535 {
+				return simError("MqttPort", "1..65535")
+			}
+			s.mqtt.port = n
+		}
+		return map[string]interface{}{"MqttPort": s.mqtt.port}
+	}
+
+	setting := settings[name]
+	if name == "grouptopic" && index > 1 {
+		return unknownCommand()
+	}
+	if payload != "" {
+		*setting.value = payload
+	}
+	if name == "mqttpassword" {
+		return map[string]interface{}{setting.key: strings.Repeat("*", len(s.mqtt.password))}
+	}
+	return map[string]interface{}{setting.key: *setting.value}
+}
+
+// last two bytes of the mac address, which tasmota uses in hostnames
+func (s *simulator) macSuffix() int {
+	n, _ := strconv.ParseUint(strings.ReplaceAll(s.mac[len(s.mac)-5:], ":", ""), 16, 16)
+	return int(n)
+}
+
+// connections made to the mqtt host, the simulator is connected once whenever it has one
+func (s *simulator) mqttCount() int {
+	if s.mqtt.host == "" {
+		return 0
+	}
+	return 1
+}
+
+// start a wifi scan with a payload, or show how it went without one, networks are numbered
+// NET1, NET2, etc and tasmota gives their numbers as strings
+func (s *simulator) wifiScan(payload string) map[string]interface{} {
+	scanning := !s.scanStarted.IsZero() && time.Since(s.scanStarted) < simScanTime
+
+	if payload != "" {
+		if scanning {
+			return map[string]interface{}{"WifiScan": "Busy"}
+		}
+		s.scanStarted = time.Now()
+		return map[string]interface{}{"WifiScan": "Started"}
+	}
+
+	switch {
+	case s.scanStarted.IsZero():
+		return map[string]interface{}{"WifiScan": "Not Started"}
+	case scanning:
+		return map[string]interface{}{"WifiScan": "Scanning"}
+	}
+
+	own := simNetwork{ssid: s.wifi.ssid, bssid: s.wifi.bssid, channel: s.wifi.channel, signal: s.wifi.signal, encryption: "WPA2/PSK"}
+	networks := map[string]interface{}{}
+	for i, n := range append([]simNetwork{own}, simNeighbours...) {
+		networks[fmt.Sprintf("NET%d", i+1)] = map[string]interface{}{
+			"SSId":       n.ssid,
+			"BSSId":      n.bssid,
+			"Channel":    strconv.Itoa(n.channel),
+			"Signal":     strconv.Itoa(n.signal),
+			"RSSI":       strconv.Itoa(wifiQuality(n.signal)),
+			"Encryption": n.encryption,
+		}
+	}
+	return map[string]interface{}{"WifiScan": networks}
+}
+
+// wifi quality in percent from a signal in dBm, the way tasmota works it out
+func wifiQuality(signal int) int {
+	switch {
+	case signal <= -100:
+		return 0
+	case signal >= -50:
+		return 100
+	}
+	return 2 * (signal + 100)
+}
+
+// time since the device started, as tasmota shows it: 1T02:03:04
+func (s *simulator) uptime() string {
+	d := time.Since(s.started)
+	days := int(d.Hours()) / 24
+	return fmt.Sprintf("%dT%02d:%02d:%02d", days, int(d.Hours())%24, int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+// split a command into its lower case name, index and payload, e.g. "Power2 On" is power, 2, On,
+// the index is -1 when there isn't one
+func splitCommand(command string) (string, int, string) {
+	word, payload := command, ""
+	if i := strings.IndexAny(command, " \t"); i >= 0 {
+		word, payload = command[:i], strings.TrimSpace(command[i+1:])
+	}
+
+	end := len(word)
+	for end > 0 && unicode.IsDigit(rune(word[end-1])) {
+		end--
+	}
+
+	index := -1
+	if end < len(word) {
+		index, _ = strconv.Atoi(word[end:])
+	}
+	return strings.ToLower(word[:end]), index, payload
+}
+
+// set a log level from a payload, returning the level
+func setLevel(level *int, payload string) int {
+	if n, err := strconv.Atoi(payload); err == nil && n >= 0 && n <= 4 {
+		*level = n
+	}
+	return *level
+}
+
+// parse a colour given as RRGGBB, #RRGGBB or R,G,B
+func parseColor(payload string) ([3]int, bool) {
+	var color [3]int
+
+	if parts := strings.Split(payload, ","); len(parts) == 3 {
+		for i, p := range parts {
+			n, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil || n < 0 || n > 255 {
+				return color, false
+			}
+			color[i] = n
+		}
+		return color, true
+	}
+
+	hex := strings.TrimPrefix(payload, "#")
+	if len(hex) != 6 {
+		return color, false
+	}
+	for i := range color {
+		n, err := strconv.ParseUint(hex[i*2:i*2+2], 16, 8)
+		if err != nil {
+			return color, false
+		}
+		color[i] = int(n)
+	}
+	return color, true
+}
+
+// parse on, off, 1 or 0
+func parseOnOff(payload string) (bool, bool) {
+	switch strings.ToLower(payload) {
+	case "on", "1":
+		return true, true
+	case "off", "0":
+		return false, true
+	}
+	return false, false
+}
+
+func onOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
+
+func unknownCommand() map[string]interface{} {
+	return map[string]interface{}{"Command": "Unknown"}
+}
+
+// response to a command given an invalid payload
+func simError(command, expected string) map[string]interface{} {
+	return map[string]interface{}{command: "Error, expected " + expected}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// send a command to a simulator, decoding the response into v
+func simCommand(t *testing.T, server *httptest.Server, command string, v interface{}) {
+	t.Helper()
+
+	resp, err := http.Get(server.URL + "/cm?cmnd=" + url.QueryEscape(command))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("%s: %s", command, err)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		name    string
+		index   int
+		payload string
+	}{
+		{"Power", "power", -1, ""},
+		{"Power2 On", "power", 2, "On"},
+		{"Status0", "status", 0, ""},
+		{"Status 11", "status", -1, "11"},
+		{"Backlog Power On; Dimmer 50", "backlog", -1, "Power On; Dimmer 50"},
+		{"Rule1 on System#Boot do Power on endon", "rule", 1, "on System#Boot do Power on endon"},
+	}
+
+	for _, tt := range tests {
+		name, index, payload := splitCommand(tt.command)
+		if name != tt.name || index != tt.index || payload != tt.payload {
+			t.Errorf("splitCommand(%q) = %q, %d, %q, want %q, %d, %q", tt.command, name, index, payload, tt.name, tt.index, tt.payload)
+		}
+	}
+}
+
+func TestSimulatorStatus(t *testing.T) {
+	sim := newSimulator("lamp", 1)
+	server := httptest.NewServer(sim)
+	defer server.Close()
+
+	simCommand(t, server, "Power On", &map[string]interface{}{})
+
+	var status StatusResponse
+	simCommand(t, server, "Status 0", &status)
+	if status.Status.DeviceName != "lamp" || status.Status.FriendlyName[0] != "lamp" {
+		t.Errorf("Status.DeviceName = %q, FriendlyName = %v, want lamp", status.Status.DeviceName, status.Status.FriendlyName)
+	}
+	if status.Status.Power != 1 {
+		t.Errorf("Status.Power = %d, want 1", status.Status.Power)
+	}
+	if status.StatusSTS.Power != "ON" {
+		t.Errorf("StatusSTS.POWER = %q, want ON", status.StatusSTS.Power)
+	}
+	if status.StatusNET.Mac == "" || status.StatusFWR.Version == "" {
+		t.Errorf("status 0 is missing sections: %+v", status)
+	}
+
+	// a single section, as power verification reads it
+	var sts StateResponse
+	simCommand(t, server, "Status 11", &sts)
+	if sts.StatusSTS["POWER"] != "ON" {
+		t.Errorf("Status 11 POWER = %v, want ON", sts.StatusSTS["POWER"])
+	}
+
+	var pth struct {
+		StatusPTH map[string]interface{} `json:"StatusPTH"`
+	}
+	simCommand(t, server, "Status 9", &pth)
+	if _, ok := pth.StatusPTH["PowerHigh"]; !ok {
+		t.Errorf("Status 9 = %v, want power thresholds", pth)
+	}
+
+	var unknown map[string]interface{}
+	simCommand(t, server, "Status 99", &unknown)
+	if unknown["Command"] != "Unknown" {
+		t.Errorf("Status 99 = %v, want unknown command", unknown)
+	}
+}
+
+func TestSimulatorPower(t *testing.T) {
+	sim := newSimulator("strip", 3)
+	server := httptest.NewServer(sim)
+	defer server.Close()
+
+	tests := []struct {
+		command string
+		want    map[string]interface{}
+	}{
+		{"Power2 On", map[string]interface{}{"POWER2": "ON"}},
+		{"Power2 Toggle", map[string]interface{}{"POWER2": "OFF"}},
+		{"Power On", map[string]interface{}{"POWER1": "ON"}},
+		{"Power0 Off", map[string]interface{}{"POWER1": "OFF", "POWER2": "OFF", "POWER3": "OFF"}},
+		{"Power4 On", map[string]interface{}{"Command": "Unknown"}},
+		{"Backlog Power1 On; Power3 On", map[string]interface{}{"POWER1": "ON", "POWER3": "ON"}},
+		{"Power3", map[string]interface{}{"POWER3": "ON"}},
+	}
+
+	for _, tt := range tests {
+		var got map[string]interface{}
+		simCommand(t, server, tt.command, &got)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s = %v, want %v", tt.command, got, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("%s = %v, want %v", tt.command, got, tt.want)
+			}
+		}
+	}
+
+	if mask := sim.powerMask(); mask != 5 {
+		t.Errorf("power mask = %b, want 101", mask)
+	}
+}
+
+func TestSimulatorLights(t *testing.T) {
+	sim := newSimulator("bulb", 1)
+	server := httptest.NewServer(sim)
+	defer server.Close()
+
+	var got map[string]interface{}
+	simCommand(t, server, "Dimmer 40", &got)
+	if got["Dimmer"] != 40.0 || got["POWER"] != "ON" {
+		t.Errorf("Dimmer 40 = %v", got)
+	}
+
+	simCommand(t, server, "Dimmer 101", &got)
+	if !strings.HasPrefix(got["Dimmer"].(string), "Error") {
+		t.Errorf("Dimmer 101 = %v, want an error", got)
+	}
+
+	for _, command := range []string{"Color FF8000", "Color 255,128,0", "Color #ff8000"} {
+		got = nil
+		simCommand(t, server, command, &got)
+		if got["Color"] != "FF8000" {
+			t.Errorf("%s = %v, want Color FF8000", command, got)
+		}
+	}
+}
+
+func TestSimulatorTimersAndRules(t *testing.T) {
+	sim := newSimulator("lamp", 1)
+	server := httptest.NewServer(sim)
+	defer server.Close()
+
+	simCommand(t, server, `Timer5 {"Enable":1,"Time":"06:30","Days":"0111110","Action":1}`, &map[string]interface{}{})
+
+	var timers AllTimers
+	simCommand(t, server, "Timers", &timers)
+	if timers.Timers != "ON" {
+		t.Errorf("Timers = %q, want ON", timers.Timers)
+	}
+	if timers.Timer5.Enable != 1 || timers.Timer5.Time != "06:30" || timers.Timer5.Days != "0111110" {
+		t.Errorf("Timer5 = %+v", timers.Timer5)
+	}
+	if timers.Timer16.Output != 1 || timers.Timer16.Time != "00:00" {
+		t.Errorf("Timer16 = %+v, want the default timer", timers.Timer16)
+	}
+
+	var rule struct {
+		Rule1 struct {
+			State string
+			Rules string
+		}
+	}
+	simCommand(t, server, "Rule1 on Power1#State=1 do Dimmer 50 endon", &rule)
+	simCommand(t, server, "Rule1 1", &rule)
+	if rule.Rule1.State != "ON" || rule.Rule1.Rules != "on Power1#State=1 do Dimmer 50 endon" {
+		t.Errorf("Rule1 = %+v", rule.Rule1)
+	}
+}
+
+func TestSimulatorPassword(t *testing.T) {
+	sim := newSimulator("locked", 1)
+	sim.password = "s3cret"
+	server := httptest.NewServer(sim)
+	defer server.Close()
+
+	var got map[string]interface{}
+	simCommand(t, server, "Power", &got)
+	if _, ok := got["WARNING"]; !ok {
+		t.Errorf("Power without a password = %v, want a warning", got)
+	}
+
+	resp, err := http.Get(server.URL + "/cm?user=admin&password=s3cret&cmnd=Power")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if string(b) != `{"POWER":"OFF"}` {
+		t.Errorf("Power with a password = %s", b)
+	}
+}
+
+func TestSimulatorConsole(t *testing.T) {
+	sim := newSimulator("lamp", 1)
+	server := httptest.NewServer(sim)
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	dev := Device{Name: host, Host: host, Timeout: defaultTestTimeout}
+
+	lines, next, err := readWebLog(dev, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], "APP: Restarted") {
+		t.Errorf("first lines = %q", lines)
+	}
+
+	simCommand(t, server, "Power On", &map[string]interface{}{})
+
+	lines, _, err = readWebLog(dev, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "CMD: Power On") || !strings.HasSuffix(lines[1], `RSL: RESULT = {"POWER":"ON"}`) {
+		t.Errorf("lines after Power On = %q", lines)
+	}
+}
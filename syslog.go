@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// tasmota prefixes syslog messages with a priority, e.g. <134>
+var syslogPriority = regexp.MustCompile(`^<\d{1,3}>`)
+
+// characters kept out of log file names, which also covers path separators
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// receive syslog messages from devices and print them or write them to per device files
+func runSyslogServer(args []string) error {
+	conn, err := net.ListenPacket("udp", viper.GetString("listen"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return err
+	}
+
+	// devices of a group that couldn't be configured are reported when the server stops, so
+	// the others can still be listened to
+	var configured error
+	if viper.GetBool("configure") {
+		configured = configureSyslog(port, args)
+		var group *GroupError
+		if configured != nil && !errors.As(configured, &group) {
+			return configured
+		}
+	}
+
+	logdir := viper.GetString("logdir")
+	if logdir != "" {
+		if err := os.MkdirAll(logdir, 0755); err != nil {
+			return err
+		}
+	}
+
+	names := deviceAddresses()
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	// close the connection on interrupt so any open files get closed
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	stopped := make(chan struct{})
+	go func() {
+		<-interrupt
+		close(stopped)
+		conn.Close()
+	}()
+
+	fmt.Printf("Listening for syslog messages on %s\n", conn.LocalAddr())
+
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-stopped:
+				return configured
+			default:
+				return err
+			}
+		}
+
+		source, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			source = addr.String()
+		}
+		name, ok := names[source]
+		if !ok {
+			name = source
+		}
+
+		message := strings.TrimSpace(syslogPriority.ReplaceAllString(string(buf[:n]), ""))
+		line := fmt.Sprintf("%s %s", time.Now().Format("2006-01-02 15:04:05"), message)
+
+		if logdir == "" {
+			fmt.Printf("%s %s\n", name, line)
+			continue
+		}
+
+		f, ok := files[name]
+		if !ok {
+			f, err = os.OpenFile(filepath.Join(logdir, logFileName(name)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				continue
+			}
+			files[name] = f
+		}
+		fmt.Fprintln(f, line)
+	}
+}
+
+// point the LogHost and LogPort of devices at this machine, all configured devices unless given a target
+func configureSyslog(port string, args []string) error {
+	targets, group, err := resolveTargetsOrAll(args)
+	if err != nil {
+		return err
+	}
+
+	level := viper.GetInt("level")
+
+	var failed error
+	failures := 0
+	for _, dev := range targets {
+		loghost, err := localAddressFor(dev.Host)
+		if err != nil {
+			err = fmt.Errorf("%s: could not determine local address: %s", dev.Name, err)
+		} else {
+			backlog := fmt.Sprintf("Backlog LogHost %s; LogPort %s; SysLog %d", loghost, port, level)
+			_, err = sendTasmota(dev, url.QueryEscape(backlog))
+		}
+		if err != nil {
+			if !group {
+				return err
+			}
+			// carry on with the rest of the group
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			if failed == nil {
+				failed = err
+			}
+			failures++
+			continue
+		}
+		fmt.Printf("%s: logging to %s:%s\n", dev.Name, loghost, port)
+	}
+
+	if failed != nil {
+		return &GroupError{Failures: failures, Total: len(targets), Err: failed}
+	}
+	return nil
+}
+
+// the local ip address used to reach a device
+func localAddressFor(address string) (string, error) {
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(514)))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// map of device ip addresses to device names
+func deviceAddresses() map[string]string {
+	names := make(map[string]string)
+	for _, name := range deviceNames() {
+		dev, err := getDevice(name)
+		if err != nil {
+			continue
+		}
+		address := dev.Host
+		if h, _, err := net.SplitHostPort(address); err == nil {
+			address = h
+		}
+
+		if net.ParseIP(address) != nil {
+			names[address] = name
+			continue
+		}
+
+		// device is configured by hostname
+		ips, err := net.LookupHost(address)
+		if err != nil {
+			if verbose {
+				fmt.Printf("Could not resolve %s: %s\n", address, err)
+			}
+			continue
+		}
+		for _, ip := range ips {
+			names[ip] = name
+		}
+	}
+	return names
+}
+
+// the log file of a device, keeping names and addresses from making paths outside the log directory
+func logFileName(name string) string {
+	name = filepath.Base(unsafeFileChars.ReplaceAllString(name, "_"))
+	if strings.Trim(name, ".") == "" {
+		name = "unknown"
+	}
+	return name + ".log"
+}
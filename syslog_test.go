@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestLogFileName(t *testing.T) {
+	tests := map[string]string{
+		"lamp":                "lamp.log",
+		"172.28.10.12":        "172.28.10.12.log",
+		"fe80::1%eth0":        "fe80__1_eth0.log",
+		"../../etc/passwd":    ".._.._etc_passwd.log",
+		`..\..\windows\win`:   ".._.._windows_win.log",
+		"/":                   "_.log",
+		"..":                  "unknown.log",
+		"":                    "unknown.log",
+		"kitchen light (old)": "kitchen_light__old_.log",
+	}
+	for name, want := range tests {
+		if got := logFileName(name); got != want {
+			t.Errorf("logFileName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
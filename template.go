@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// functions available to --format templates
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		s, err := json.Marshal(v)
+		return string(s), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+	"split": strings.Split,
+	"join": func(sep string, v interface{}) string {
+		switch list := v.(type) {
+		case []string:
+			return strings.Join(list, sep)
+		case []interface{}:
+			s := make([]string, len(list))
+			for i, item := range list {
+				s[i] = fmt.Sprint(item)
+			}
+			return strings.Join(s, sep)
+		}
+		return fmt.Sprint(v)
+	},
+
+	// padding
+	"padleft": func(width int, v interface{}) string {
+		return fmt.Sprintf("%*v", width, v)
+	},
+	"padright": func(width int, v interface{}) string {
+		return fmt.Sprintf("%-*v", width, v)
+	},
+
+	// arithmetic and unit conversion
+	"add": func(a, b interface{}) (float64, error) {
+		return calc(a, b, func(x, y float64) float64 { return x + y })
+	},
+	"sub": func(a, b interface{}) (float64, error) {
+		return calc(a, b, func(x, y float64) float64 { return x - y })
+	},
+	"mul": func(a, b interface{}) (float64, error) {
+		return calc(a, b, func(x, y float64) float64 { return x * y })
+	},
+	"div": func(a, b interface{}) (float64, error) {
+		return calc(a, b, func(x, y float64) float64 { return x / y })
+	},
+	"round": func(places int, v interface{}) (float64, error) {
+		f, err := toFloat(v)
+		if err != nil {
+			return 0, err
+		}
+		shift := math.Pow(10, float64(places))
+		return math.Round(f*shift) / shift, nil
+	},
+	"fahrenheit": func(v interface{}) (float64, error) {
+		c, err := toFloat(v)
+		return c*9/5 + 32, err
+	},
+	"celsius": func(v interface{}) (float64, error) {
+		f, err := toFloat(v)
+		return (f - 32) * 5 / 9, err
+	},
+	"bytes": func(v interface{}) (string, error) {
+		f, err := toFloat(v)
+		if err != nil {
+			return "", err
+		}
+		return humanBytes(f), nil
+	},
+
+	// durations
+	"duration": func(v interface{}) (string, error) {
+		f, err := toFloat(v)
+		if err != nil {
+			return "", err
+		}
+		return time.Duration(f * float64(time.Second)).String(), nil
+	},
+	"seconds": uptimeSeconds,
+}
+
+// parse a --format template
+func parseTemplate(format string) (*template.Template, error) {
+	return template.New("format").Funcs(templateFuncs).Parse(format)
+}
+
+// render output through the --format template
+func renderTemplate(w io.Writer, format string, o output) error {
+	tmpl, err := parseTemplate(format)
+	if err != nil {
+		return err
+	}
+
+	// use the same field names as json output rather than the go field names
+	data, err := o.source()
+	if err != nil {
+		return err
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+// apply an arithmetic function to two template values
+func calc(a, b interface{}, f func(x, y float64) float64) (float64, error) {
+	x, err := toFloat(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+	return f(x, y), nil
+}
+
+// convert a template value to a number
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(n), 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// human readable size of a number of bytes
+func humanBytes(b float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+	return strconv.FormatFloat(math.Round(b*10)/10, 'f', -1, 64) + units[i]
+}
+
+// number of seconds in a tasmota uptime such as 1T02:03:04
+func uptimeSeconds(uptime string) (int, error) {
+	days := 0
+	if d, rest, found := strings.Cut(uptime, "T"); found {
+		n, err := strconv.Atoi(d)
+		if err != nil {
+			return 0, fmt.Errorf("invalid uptime %s", uptime)
+		}
+		days = n
+		uptime = rest
+	}
+
+	var h, m, s int
+	if _, err := fmt.Sscanf(uptime, "%d:%d:%d", &h, &m, &s); err != nil {
+		return 0, fmt.Errorf("invalid uptime %s", uptime)
+	}
+
+	return days*86400 + h*3600 + m*60 + s, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	var data interface{}
+	decoder := json.NewDecoder(strings.NewReader(`{
+		"Status": {"FriendlyName": ["Lamp", "Light"], "DeviceName": "lamp"},
+		"StatusSNS": {"ENERGY": {"Power": 1500, "Total": "12.3456"}, "DS18B20": {"Temperature": 21.5}},
+		"StatusSTS": {"UptimeSec": 93784, "Uptime": "1T02:03:04"},
+		"StatusMEM": {"Heap": 25},
+		"Fraction": 1.5
+	}`))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format string
+		want   string
+		err    string
+	}{
+		{format: `{{json .Status.FriendlyName}}`, want: `["Lamp","Light"]`},
+		{format: `{{upper .Status.DeviceName}} {{lower "LAMP"}} [{{trim "  lamp "}}]`, want: "LAMP lamp [lamp]"},
+		{format: `{{join "-" (split "a,b,c" ",")}} {{join ", " .Status.FriendlyName}}`, want: "a-b-c Lamp, Light"},
+		{format: `[{{padleft 6 .Status.DeviceName}}][{{padright 6 .Status.DeviceName}}]`, want: "[  lamp][lamp  ]"},
+		{format: `{{add .StatusMEM.Heap 5}} {{sub 10 .StatusMEM.Heap}} {{mul .StatusMEM.Heap 2}} {{div .StatusSNS.ENERGY.Power 1000}}`, want: "30 -15 50 1.5"},
+		{format: `{{round 2 .StatusSNS.ENERGY.Total}} {{round 0 .StatusSNS.ENERGY.Total}}`, want: "12.35 12"},
+		{format: `{{fahrenheit .StatusSNS.DS18B20.Temperature}} {{celsius 212}}`, want: "70.7 100"},
+		{format: `{{bytes 512}} {{bytes (mul .StatusMEM.Heap 1024)}} {{bytes 1572864}}`, want: "512B 25KB 1.5MB"},
+		{format: `{{duration .StatusSTS.UptimeSec}} {{duration .Fraction}} {{duration "0.25"}}`, want: "26h3m4s 1.5s 250ms"},
+		{format: `{{seconds .StatusSTS.Uptime}} {{seconds "00:01:05"}}`, want: "93784 65"},
+		{format: `{{add .Status.DeviceName 1}}`, err: `parsing "lamp"`},
+		{format: `{{seconds "soon"}}`, err: "invalid uptime soon"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseTemplate(tt.format)
+		if err != nil {
+			t.Errorf("parseTemplate(%s): %s", tt.format, err)
+			continue
+		}
+		var out strings.Builder
+		err = tmpl.Execute(&out, data)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s error = %v, want %q", tt.format, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.format, err)
+		} else if out.String() != tt.want {
+			t.Errorf("%s = %q, want %q", tt.format, out.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// how long a device gets to finish a wifi scan, it drops off the network while scanning
+const wifiScanTimeout = 30 * time.Second
+
+// how often a device is asked whether its wifi scan has finished
+const wifiScanPoll = 500 * time.Millisecond
+
+var wifiCmd = &cobra.Command{
+	Use:   "wifi [device|group]",
+	Short: "Show the wifi of devices, grouped by access point",
+	Long: `Show the wifi connection of a device or group, or of all configured devices, grouped by the
+access point and channel they are connected to. Devices are asked at the same time, so this is
+quick even with many devices.
+
+Devices are flagged when their signal or quality is below the signal and rssi thresholds of the
+health command, or when they have reconnected more than --reconnects times since restarting.
+
+With --scan a device scans for the networks around it, which takes a few seconds during which
+it may drop off the network.`,
+	Example: `  tasmota-cli wifi
+  tasmota-cli wifi downstairs --reconnects 5
+  tasmota-cli wifi lamp --scan`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		devices, group, err := resolveTargetsOrAll(args)
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool("scan") {
+			if group {
+				return errors.New("--scan works on a single device, not a group")
+			}
+			return runWifiScan(devices[0])
+		}
+
+		if len(devices) == 0 {
+			return errors.New("no device given and no devices are configured")
+		}
+		return runWifi(devices, group)
+	},
+}
+
+func init() {
+	wifiCmd.Flags().Int("reconnects", 3, "Flag devices that reconnected to wifi more than this many times since restarting")
+	wifiCmd.Flags().Bool("scan", false, "Scan for the networks a device can see")
+
+	rootCmd.AddCommand(wifiCmd)
+}
+
+// the wifi connection of a device
+type WifiResult struct {
+	Device    string   `json:"Device"`
+	SSID      string   `json:"SSID"`
+	BSSID     string   `json:"BSSID"`
+	Channel   int      `json:"Channel"`
+	AP        int      `json:"AP"`
+	Signal    int      `json:"Signal"`
+	RSSI      int      `json:"RSSI"`
+	LinkCount int      `json:"LinkCount"`
+	Downtime  string   `json:"Downtime"`
+	State     string   `json:"State"`
+	Problems  []string `json:"Problems,omitempty"`
+
+	failed bool
+}
+
+// a network found by a wifi scan
+type WifiNetwork struct {
+	SSID       string `json:"SSID"`
+	BSSID      string `json:"BSSID"`
+	Channel    int    `json:"Channel"`
+	Signal     int    `json:"Signal"`
+	RSSI       int    `json:"RSSI"`
+	Encryption string `json:"Encryption"`
+}
+
+// show the wifi of devices, asking them all at once
+func runWifi(devices []Device, group bool) error {
+	checks, err := healthThresholds()
+	if err != nil {
+		return err
+	}
+	reconnects := viper.GetInt("reconnects")
+
+	results := make([]WifiResult, len(devices))
+	errs := queryDevices(devices, func(i int, dev Device) error {
+		var err error
+		results[i], err = readWifi(dev)
+		return err
+	})
+
+	var failed error
+	failures := 0
+	for i, err := range errs {
+		if err == nil {
+			results[i].flag(checks, reconnects)
+			continue
+		}
+		if !group {
+			return err
+		}
+		// carry on with the rest of the devices
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		if failed == nil {
+			failed = err
+		}
+		failures++
+		results[i] = WifiResult{Device: devices[i].Name, State: "ERROR", Problems: []string{err.Error()}, failed: true}
+	}
+
+	if err := render(os.Stdout, wifiOutput(results)); err != nil {
+		return err
+	}
+
+	if failed != nil {
+		return &GroupError{Failures: failures, Total: len(devices), Err: failed}
+	}
+	return nil
+}
+
+// read the wifi connection of a device from status 11
+func readWifi(dev Device) (WifiResult, error) {
+	response, err := sendTasmota(dev, "Status%2011")
+	if err != nil {
+		return WifiResult{}, err
+	}
+
+	res := StatusResponse{}
+	if err := decodeResponse(dev.Host, response, &res); err != nil {
+		return WifiResult{}, err
+	}
+
+	wifi := res.StatusSTS.Wifi
+	return WifiResult{
+		Device:    dev.Name,
+		SSID:      wifi.SSID,
+		BSSID:     wifi.BSSID,
+		Channel:   wifi.Channel,
+		AP:        wifi.Ap,
+		Signal:    wifi.Signal,
+		RSSI:      wifi.Rssi,
+		LinkCount: wifi.LinkCount,
+		Downtime:  wifi.Downtime,
+	}, nil
+}
+
+// flag a poor signal or quality by the thresholds of health, and reconnects above a limit
+func (r *WifiResult) flag(checks []healthCheck, reconnects int) {
+	state := healthOK
+	r.Problems = nil
+
+	for _, c := range checks {
+		var value int
+		var problem string
+		switch c.name {
+		case "signal":
+			value, problem = r.Signal, fmt.Sprintf("signal is %ddBm", r.Signal)
+		case "rssi":
+			value, problem = r.RSSI, fmt.Sprintf("quality is %d%%", r.RSSI)
+		default:
+			continue
+		}
+		if s := c.state(float64(value)); s != healthOK {
+			state = worseState(state, s)
+			r.Problems = append(r.Problems, problem)
+		}
+	}
+
+	// the first connection after restarting isn't a reconnect
+	if n := r.LinkCount - 1; n > reconnects {
+		state = worseState(state, healthWarn)
+		r.Problems = append(r.Problems, fmt.Sprintf("%d reconnects", n))
+	}
+
+	r.State = healthStates[state]
+}
+
+// output of the wifi of devices, the table is sorted by access point and channel, which the
+// default output shows devices grouped by, and devices that couldn't be reached come last
+func wifiOutput(results []WifiResult) output {
+	sorted := make([]WifiResult, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch {
+		case a.failed != b.failed:
+			return b.failed
+		case a.BSSID != b.BSSID:
+			return a.BSSID < b.BSSID
+		case a.Channel != b.Channel:
+			return a.Channel < b.Channel
+		}
+		return a.Device < b.Device
+	})
+
+	o := output{
+		Data:    sorted,
+		Columns: []string{"BSSID", "Channel", "SSID", "Device", "Signal", "RSSI", "LinkCount", "Downtime", "State", "Problems"},
+	}
+	for _, r := range sorted {
+		if r.failed {
+			o.Rows = append(o.Rows, []string{"", "", "", r.Device, "", "", "", "", r.State, strings.Join(r.Problems, ", ")})
+			continue
+		}
+		o.Rows = append(o.Rows, []string{
+			r.BSSID, strconv.Itoa(r.Channel), r.SSID, r.Device,
+			strconv.Itoa(r.Signal), strconv.Itoa(r.RSSI), strconv.Itoa(r.LinkCount), r.Downtime,
+			r.State, strings.Join(r.Problems, ", "),
+		})
+	}
+
+	// a table for each access point and channel
+	var text bytes.Buffer
+	for start := 0; start < len(sorted); {
+		end := start + 1
+		for end < len(sorted) && sorted[end].failed == sorted[start].failed &&
+			sorted[end].BSSID == sorted[start].BSSID && sorted[end].Channel == sorted[start].Channel {
+			end++
+		}
+
+		if start > 0 {
+			text.WriteString("\n")
+		}
+		first := sorted[start]
+		var rows [][]string
+		if first.failed {
+			fmt.Fprintf(&text, "Unreachable: %s\n", plural(end-start, "device"))
+			for _, r := range sorted[start:end] {
+				rows = append(rows, []string{r.Device, strings.Join(r.Problems, ", ")})
+			}
+			renderTable(&text, []string{"Device", "Error"}, rows)
+		} else {
+			fmt.Fprintf(&text, "%s %s channel %d: %s\n", first.SSID, first.BSSID, first.Channel, plural(end-start, "device"))
+			for _, r := range sorted[start:end] {
+				rows = append(rows, []string{
+					r.Device, fmt.Sprintf("%ddBm", r.Signal), fmt.Sprintf("%d%%", r.RSSI),
+					strconv.Itoa(r.LinkCount - 1), r.Downtime, r.State, strings.Join(r.Problems, ", "),
+				})
+			}
+			renderTable(&text, []string{"Device", "Signal", "Quality", "Reconnects", "Downtime", "State", "Problems"}, rows)
+		}
+		start = end
+	}
+	o.Text = text.String()
+
+	return o
+}
+
+// a count of things, e.g. 1 device or 2 devices
+func plural(n int, thing string) string {
+	if n == 1 {
+		return "1 " + thing
+	}
+	return fmt.Sprintf("%d %ss", n, thing)
+}
+
+// have a device scan for wifi networks, waiting for the scan to finish, and show what it found
+func runWifiScan(dev Device) error {
+	if _, err := sendTasmota(dev, url.QueryEscape("WifiScan 1")); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(wifiScanTimeout)
+	for {
+		time.Sleep(wifiScanPoll)
+
+		response, err := sendTasmota(dev, "WifiScan")
+		if err == nil {
+			networks, done, err := parseWifiScan(dev.Host, response)
+			if err != nil {
+				return err
+			}
+			if done {
+				return render(os.Stdout, wifiScanOutput(networks, response))
+			}
+		} else if !isRetryable(err) {
+			return err
+		}
+		// devices drop off the network while they scan, so keep asking until the deadline
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: wifi scan didn't finish within %s", dev.Name, wifiScanTimeout)
+		}
+	}
+}
+
+// parse a response to WifiScan, which is a status such as "Scanning" until the scan is done,
+// then the networks found with their numbers given as strings:
+//
+//	{"WiFiScan":{"NET1":{"SSId":"home","BSSId":"AA:BB:CC:DD:EE:FF","Channel":"6","Signal":"-65","RSSI":"70","Encryption":"WPA2/PSK"}}}
+func parseWifiScan(ip string, response []byte) ([]WifiNetwork, bool, error) {
+	// the key is matched without case, firmware has used both WifiScan and WiFiScan
+	var res struct {
+		WifiScan json.RawMessage `json:"WifiScan"`
+	}
+	if err := decodeResponse(ip, response, &res); err != nil {
+		return nil, false, err
+	}
+
+	var status string
+	if json.Unmarshal(res.WifiScan, &status) == nil {
+		return nil, false, nil
+	}
+
+	var found map[string]map[string]interface{}
+	if err := decodeResponse(ip, res.WifiScan, &found); err != nil {
+		return nil, false, err
+	}
+
+	number := func(v interface{}) int {
+		n, _ := numericValue(v)
+		return int(n)
+	}
+	text := func(v interface{}) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+
+	networks := []WifiNetwork{}
+	for _, n := range found {
+		networks = append(networks, WifiNetwork{
+			SSID:       text(n["SSId"]),
+			BSSID:      text(n["BSSId"]),
+			Channel:    number(n["Channel"]),
+			Signal:     number(n["Signal"]),
+			RSSI:       number(n["RSSI"]),
+			Encryption: text(n["Encryption"]),
+		})
+	}
+
+	// strongest first
+	sort.Slice(networks, func(i, j int) bool {
+		if networks[i].Signal != networks[j].Signal {
+			return networks[i].Signal > networks[j].Signal
+		}
+		return networks[i].BSSID < networks[j].BSSID
+	})
+	return networks, true, nil
+}
+
+// output of a wifi scan
+func wifiScanOutput(networks []WifiNetwork, raw []byte) output {
+	o := output{
+		Data:    networks,
+		Raw:     raw,
+		Columns: []string{"SSID", "BSSID", "Channel", "Signal", "RSSI", "Encryption"},
+	}
+	for _, n := range networks {
+		o.Rows = append(o.Rows, []string{
+			n.SSID, n.BSSID, strconv.Itoa(n.Channel), strconv.Itoa(n.Signal), strconv.Itoa(n.RSSI), n.Encryption,
+		})
+	}
+	return o
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWifiScan(t *testing.T) {
+	for _, status := range []string{"Not Started", "Started", "Scanning"} {
+		if _, done, err := parseWifiScan("lamp", []byte(`{"WifiScan":"`+status+`"}`)); done || err != nil {
+			t.Errorf("parseWifiScan(%s) = %t, %v, want not done", status, done, err)
+		}
+	}
+
+	response := `{"WiFiScan":{` +
+		`"NET1":{"SSId":"home","BSSId":"AA:BB:CC:DD:EE:FF","Channel":"6","Signal":"-65","RSSI":"70","Encryption":"WPA2/PSK"},` +
+		`"NET2":{"SSId":"guest","BSSId":"AA:BB:CC:DD:EE:00","Channel":11,"Signal":-58,"RSSI":84,"Encryption":"OPEN"}}}`
+	networks, done, err := parseWifiScan("lamp", []byte(response))
+	want := []WifiNetwork{
+		{SSID: "guest", BSSID: "AA:BB:CC:DD:EE:00", Channel: 11, Signal: -58, RSSI: 84, Encryption: "OPEN"},
+		{SSID: "home", BSSID: "AA:BB:CC:DD:EE:FF", Channel: 6, Signal: -65, RSSI: 70, Encryption: "WPA2/PSK"},
+	}
+	if !done || err != nil || !reflect.DeepEqual(networks, want) {
+		t.Errorf("parseWifiScan = %+v, %t, %v, want %+v", networks, done, err, want)
+	}
+
+	if _, _, err := parseWifiScan("lamp", []byte(`{"WifiScan":[1]}`)); err == nil {
+		t.Error("parseWifiScan of a list succeeded, want an error")
+	}
+}
+
+func TestWifiFlag(t *testing.T) {
+	tests := []struct {
+		result   WifiResult
+		state    string
+		problems string
+	}{
+		{WifiResult{Signal: -60, RSSI: 80, LinkCount: 1}, "OK", ""},
+		{WifiResult{Signal: -60, RSSI: 80, LinkCount: 4}, "OK", ""},
+		{WifiResult{Signal: -60, RSSI: 80, LinkCount: 5}, "WARN", "4 reconnects"},
+		{WifiResult{Signal: -78, RSSI: 44, LinkCount: 1}, "WARN", "signal is -78dBm"},
+		{WifiResult{Signal: -88, RSSI: 24, LinkCount: 9}, "CRIT", "signal is -88dBm, quality is 24%, 8 reconnects"},
+	}
+
+	for _, tt := range tests {
+		r := tt.result
+		r.flag(healthChecks, 3)
+		if r.State != tt.state || strings.Join(r.Problems, ", ") != tt.problems {
+			t.Errorf("flag(%+v) = %s %q, want %s %q", tt.result, r.State, r.Problems, tt.state, tt.problems)
+		}
+	}
+}